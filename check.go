@@ -0,0 +1,14 @@
+package main
+
+import "github.com/dubyte/dir2opds/internal/service"
+
+// runCheck is the check command, it reads every book of the libraries and
+// prints the ones that are broken. It exits with 1 when it finds any, and
+// with 2 when it can't run.
+func runCheck(args []string) int {
+	return runReport(args, "Usage: dir2opds check [flags]\n\nReads every book and lists the unreadable files, corrupt ZIP archives and EPUBs, PDFs that can't be opened, broken symlinks and files without a MIME type.",
+		func(s service.OPDS) (service.CheckReport, bool) {
+			report := s.Check()
+			return report, len(report.Problems) > 0
+		}, 1, 2)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of the environment variables that set the flags,
+// e.g. DIR2OPDS_HIDE_DOT_FILES sets -hide-dot-files.
+const envPrefix = "DIR2OPDS_"
+
+// envName returns the environment variable that sets the flag name
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadConfig sets the flags that are not in set, the ones given in the command line,
+// from the environment and then from the YAML config file, so flag > env > file.
+// The flags it sets are added to set. The repeatable flags, like -library, take
+// one value per line in their variable.
+func loadConfig(fset *flag.FlagSet, set map[string]bool, configPath string, lookupEnv func(string) (string, bool)) error {
+	var err error
+	fset.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || f.Name == "config" {
+			return
+		}
+		value, ok := lookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		set[f.Name] = true
+
+		values := []string{value}
+		if _, repeatable := f.Value.(*listFlag); repeatable {
+			values = strings.FieldsFunc(value, func(r rune) bool { return r == '\n' })
+		}
+		for _, v := range values {
+			if e := fset.Set(f.Name, strings.TrimSpace(v)); e != nil {
+				err = fmt.Errorf("%s: %w", envName(f.Name), e)
+				return
+			}
+		}
+	})
+	if err != nil || configPath == "" {
+		return err
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	var config map[string]any
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("config %s: %w", configPath, err)
+	}
+
+	for name, value := range config {
+		f := fset.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("config %s: unknown option %q", configPath, name)
+		}
+		if set[name] || value == nil {
+			continue
+		}
+		set[name] = true
+
+		values, ok := value.([]any)
+		if !ok {
+			values = []any{value}
+		}
+		for _, v := range values {
+			switch v.(type) {
+			case []any, map[string]any:
+				return fmt.Errorf("config %s: option %q must be a value", configPath, name)
+			}
+			if err := fset.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("config %s: option %q: %w", configPath, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateFlags returns an error for every flag with an invalid value
+func validateFlags() error {
+	var errs []error
+
+	if !service.ValidSort(*sortBy) {
+		errs = append(errs, fmt.Errorf("-sort %q: must be one of %s", *sortBy, strings.Join(service.SortKeys(), ", ")))
+	}
+	if service.SortNeedsMetadata(*sortBy) && !*extractMeta {
+		errs = append(errs, fmt.Errorf("-sort %s needs -extract-metadata", *sortBy))
+	}
+	if *sortOrder != "" && !service.ValidSortOrder(*sortOrder) {
+		errs = append(errs, fmt.Errorf("-sort-order %q: must be asc or desc", *sortOrder))
+	}
+	if !service.ValidMixedFolders(*mixedFolders) {
+		errs = append(errs, fmt.Errorf("-mixed-folders %q: must be acquisition or split", *mixedFolders))
+	}
+	if !service.ValidSortLocale(*sortLocale) {
+		errs = append(errs, fmt.Errorf("-sort-locale %q: must be a language tag like en or pt-BR", *sortLocale))
+	}
+
+	switch strings.ToLower(*logFormat) {
+	case "json", "text":
+	default:
+		errs = append(errs, fmt.Errorf("-log-format %q: must be json or text", *logFormat))
+	}
+
+	if p, err := strconv.Atoi(*port); err != nil || p < 1 || p > 65535 {
+		errs = append(errs, fmt.Errorf("-port %q: must be a number between 1 and 65535", *port))
+	}
+
+	if *pageSize < 0 || *pageSize > 200 {
+		errs = append(errs, fmt.Errorf("-page-size %d: must be between 0 and 200", *pageSize))
+	}
+
+	if *zipMaxSize < 0 {
+		errs = append(errs, fmt.Errorf("-zip-max-size %d: must not be negative", *zipMaxSize))
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read-header-timeout", *readHeaderTimeout},
+		{"read-timeout", *readTimeout},
+		{"write-timeout", *writeTimeout},
+		{"idle-timeout", *idleTimeout},
+		{"shutdown-timeout", *shutdownTimeout},
+	} {
+		if timeout.value < 0 {
+			errs = append(errs, fmt.Errorf("-%s %s: must not be negative", timeout.name, timeout.value))
+		}
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		errs = append(errs, errors.New("-tls-cert and -tls-key must be given together"))
+	}
+
+	if *aclFile != "" && *htpasswd == "" {
+		errs = append(errs, errors.New("-acl needs -htpasswd to know the users"))
+	}
+
+	if *htpasswd == "" && slices.ContainsFunc(parseList(*admin), func(user string) bool { return user != "*" }) {
+		errs = append(errs, errors.New("-admin needs -htpasswd to know the users, or * for everybody"))
+	}
+
+	if *relatedBooks && !*extractMeta {
+		errs = append(errs, errors.New("-related-books needs -extract-metadata"))
+	}
+	if *indexRefresh <= 0 {
+		errs = append(errs, fmt.Errorf("-index-refresh %s: must be positive", *indexRefresh))
+	}
+
+	if *signingKey != "" && *htpasswd == "" {
+		errs = append(errs, errors.New("-signing-key needs -htpasswd, without it the links work anyway"))
+	}
+	if *signingTTL <= 0 {
+		errs = append(errs, fmt.Errorf("-signing-ttl %s: must be positive", *signingTTL))
+	}
+
+	if *redirectPort != "" {
+		if p, err := strconv.Atoi(*redirectPort); err != nil || p < 1 || p > 65535 {
+			errs = append(errs, fmt.Errorf("-redirect-port %q: must be a number between 1 and 65535", *redirectPort))
+		} else if *tlsCert == "" {
+			errs = append(errs, errors.New("-redirect-port needs -tls-cert and -tls-key"))
+		} else if *socket != "" {
+			errs = append(errs, errors.New("-redirect-port redirects to -port, not to -socket"))
+		}
+	}
+
+	if *baseURL != "" {
+		if err := validateHTTPURL(*baseURL); err != nil {
+			errs = append(errs, fmt.Errorf("-url: %w", err))
+		}
+	}
+
+	if err := validateHTTPURL(*s3Endpoint); err != nil {
+		errs = append(errs, fmt.Errorf("-s3-endpoint: %w", err))
+	}
+
+	if err := validateMimeMap(*mimeMapStr); err != nil {
+		errs = append(errs, fmt.Errorf("-mime-map: %w", err))
+	}
+
+	for _, pattern := range ignorePatterns {
+		if !service.ValidIgnorePattern(pattern) {
+			errs = append(errs, fmt.Errorf("-ignore %q: invalid pattern", pattern))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateHTTPURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q must be an http or https URL", s)
+	}
+	return nil
+}
+
+// validateMimeMap checks every pair of a mime map like .mobi:application/x-mobipocket-ebook
+func validateMimeMap(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		ext, mimeType, ok := strings.Cut(pair, ":")
+		if !ok || !strings.HasPrefix(ext, ".") || !strings.Contains(mimeType, "/") {
+			return fmt.Errorf("%q must be .extension:type/subtype", pair)
+		}
+	}
+	return nil
+}
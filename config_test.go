@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	return p
+}
+
+func envMap(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func givenFlags(fset *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fset.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+func TestLoadConfig(t *testing.T) {
+	newFlagSet := func() (*flag.FlagSet, *string, *string, *bool, *int, *listFlag) {
+		fset := flag.NewFlagSet("test", flag.ContinueOnError)
+		var libs listFlag
+		fset.Var(&libs, "library", "")
+		fset.String("config", "", "")
+		return fset,
+			fset.String("sort", "name", ""),
+			fset.String("dir", "./books", ""),
+			fset.Bool("hide-dot-files", true, ""),
+			fset.Int("page-size", 50, ""),
+			&libs
+	}
+
+	config := writeConfig(t, `
+sort: date
+dir: /srv/books
+hide-dot-files: false
+page-size: 100
+library:
+  - fiction=/srv/fiction
+  - comics=/srv/comics;sort=size
+`)
+
+	t.Run("file", func(t *testing.T) {
+		fset, sort, dir, hideDotFiles, pageSize, libs := newFlagSet()
+		require.NoError(t, fset.Parse(nil))
+		require.NoError(t, loadConfig(fset, givenFlags(fset), config, envMap(nil)))
+
+		assert.Equal(t, "date", *sort)
+		assert.Equal(t, "/srv/books", *dir)
+		assert.False(t, *hideDotFiles)
+		assert.Equal(t, 100, *pageSize)
+		assert.Equal(t, listFlag{"fiction=/srv/fiction", "comics=/srv/comics;sort=size"}, *libs)
+	})
+
+	t.Run("flag > env > file", func(t *testing.T) {
+		fset, sort, dir, hideDotFiles, pageSize, libs := newFlagSet()
+		require.NoError(t, fset.Parse([]string{"-sort", "size"}))
+		env := envMap(map[string]string{
+			"DIR2OPDS_SORT":    "name",
+			"DIR2OPDS_DIR":     "/env/books",
+			"DIR2OPDS_LIBRARY": "a=/a\nb=/b",
+		})
+		require.NoError(t, loadConfig(fset, givenFlags(fset), config, env))
+
+		assert.Equal(t, "size", *sort)
+		assert.Equal(t, "/env/books", *dir)
+		assert.False(t, *hideDotFiles)
+		assert.Equal(t, 100, *pageSize)
+		assert.Equal(t, listFlag{"a=/a", "b=/b"}, *libs)
+	})
+
+	t.Run("no file", func(t *testing.T) {
+		fset, sort, _, _, _, _ := newFlagSet()
+		require.NoError(t, fset.Parse(nil))
+		require.NoError(t, loadConfig(fset, givenFlags(fset), "", envMap(map[string]string{"DIR2OPDS_SORT": "date"})))
+		assert.Equal(t, "date", *sort)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"unknown option": "colour: blue\n",
+			"invalid value":  "page-size: lots\n",
+			"nested value":   "sort:\n  by: name\n",
+			"invalid yaml":   "sort: [name\n",
+		} {
+			fset, _, _, _, _, _ := newFlagSet()
+			require.NoError(t, fset.Parse(nil))
+			assert.Error(t, loadConfig(fset, givenFlags(fset), writeConfig(t, content), envMap(nil)), name)
+		}
+
+		fset, _, _, _, _, _ := newFlagSet()
+		require.NoError(t, fset.Parse(nil))
+		err := loadConfig(fset, givenFlags(fset), "", envMap(map[string]string{"DIR2OPDS_PAGE_SIZE": "lots"}))
+		assert.ErrorContains(t, err, "DIR2OPDS_PAGE_SIZE")
+
+		assert.Error(t, loadConfig(fset, givenFlags(fset), filepath.Join(t.TempDir(), "missing.yaml"), envMap(nil)))
+	})
+}
+
+func TestValidateFlags(t *testing.T) {
+	oldSort, oldPort, oldPageSize, oldURL, oldMimeMap := *sortBy, *port, *pageSize, *baseURL, *mimeMapStr
+	oldSortLocale, oldSortOrder, oldMixedFolders := *sortLocale, *sortOrder, *mixedFolders
+	oldIdleTimeout, oldTLSCert, oldRedirectPort, oldACL, oldSigningTTL := *idleTimeout, *tlsCert, *redirectPort, *aclFile, *signingTTL
+	oldSocket := *socket
+	oldRelatedBooks, oldExtractMeta, oldIndexRefresh, oldAdmin := *relatedBooks, *extractMeta, *indexRefresh, *admin
+	defer func() {
+		*sortBy, *port, *pageSize, *baseURL, *mimeMapStr = oldSort, oldPort, oldPageSize, oldURL, oldMimeMap
+		*idleTimeout, *tlsCert, *redirectPort, *aclFile, *signingTTL = oldIdleTimeout, oldTLSCert, oldRedirectPort, oldACL, oldSigningTTL
+		*sortLocale, *sortOrder, *mixedFolders = oldSortLocale, oldSortOrder, oldMixedFolders
+		*relatedBooks, *extractMeta, *indexRefresh, *admin = oldRelatedBooks, oldExtractMeta, oldIndexRefresh, oldAdmin
+		*socket = oldSocket
+		ignorePatterns = nil
+	}()
+
+	require.NoError(t, validateFlags())
+	*admin = "*"
+	require.NoError(t, validateFlags(), "everybody is an admin without users")
+
+	*sortBy = "title"
+	*extractMeta = false
+	err := validateFlags()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `-sort title needs -extract-metadata`)
+	*extractMeta = true
+
+	*tlsCert, *redirectPort, *socket = "cert.pem", "80", "/run/dir2opds/dir2opds.sock"
+	err = validateFlags()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `-redirect-port redirects to -port, not to -socket`)
+	*socket = ""
+
+	*sortBy = "colour"
+	*sortLocale = "not a language"
+	*sortOrder = "up"
+	*mixedFolders = "both"
+	*port = "http"
+	*pageSize = 500
+	*baseURL = "opds.example.com"
+	*mimeMapStr = ".mobi:application/x-mobipocket-ebook,azw3"
+	*idleTimeout = -time.Second
+	*tlsCert = "cert.pem"
+	*redirectPort = "80"
+	*aclFile = "acl.yaml"
+	*signingTTL = 0
+	*relatedBooks = true
+	*extractMeta = false
+	*indexRefresh = 0
+	*admin = "*, alice"
+	ignorePatterns = listFlag{"*.tmp", "[a-"}
+
+	err = validateFlags()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `-sort "colour": must be one of name, title, author, series, date, size`)
+	assert.Contains(t, err.Error(), `-sort-locale "not a language"`)
+	assert.Contains(t, err.Error(), `-sort-order "up": must be asc or desc`)
+	assert.Contains(t, err.Error(), `-mixed-folders "both": must be acquisition or split`)
+	assert.Contains(t, err.Error(), `-port "http"`)
+	assert.Contains(t, err.Error(), `-page-size 500`)
+	assert.Contains(t, err.Error(), `-url`)
+	assert.Contains(t, err.Error(), `-mime-map: "azw3"`)
+	assert.Contains(t, err.Error(), `-idle-timeout -1s: must not be negative`)
+	assert.Contains(t, err.Error(), `-tls-cert and -tls-key must be given together`)
+	assert.NotContains(t, err.Error(), `-redirect-port`)
+	assert.Contains(t, err.Error(), `-acl needs -htpasswd`)
+	assert.Contains(t, err.Error(), `-signing-ttl 0s: must be positive`)
+	assert.Contains(t, err.Error(), `-related-books needs -extract-metadata`)
+	assert.Contains(t, err.Error(), `-index-refresh 0s: must be positive`)
+	assert.Contains(t, err.Error(), `-admin needs -htpasswd`)
+	assert.Contains(t, err.Error(), `-ignore "[a-": invalid pattern`)
+	assert.NotContains(t, err.Error(), `*.tmp`)
+}
@@ -0,0 +1,12 @@
+package main
+
+import "github.com/dubyte/dir2opds/internal/service"
+
+// runDuplicates is the duplicates command, it prints the books stored more
+// than once in the libraries
+func runDuplicates(args []string) int {
+	return runReport(args, "Usage: dir2opds duplicates [flags]\n\nLists the books with the same content, or the same title and author, the best copy first.",
+		func(s service.OPDS) (service.DuplicateReport, bool) {
+			return s.Duplicates(), false
+		}, 0, 1)
+}
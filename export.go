@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runExport is the export command, it renders the library to static files
+// that any web server can host. It exits with 1 when some pages or books
+// could not be written, and with 2 when it can't run.
+func runExport(args []string) int {
+	out := flag.String("out", "", "The folder to write the static site to.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: dir2opds export -out site [flags]\n\nRenders the feeds, the HTML view, the covers and the OpenSearch document of the library to static files in -out, next to a copy of the books. Use -url with the address the site will be hosted at.\n\n")
+		flag.PrintDefaults()
+	}
+
+	libs, err := commandLibraries(args)
+	if err == nil && *out == "" {
+		err = errors.New("-out is required")
+	}
+	if err == nil && len(libs) > 1 {
+		err = errors.New("export works on a single library, use -dir")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 2
+	}
+
+	report, err := libs[0].OPDS.Export(*out)
+	if err == nil {
+		err = report.Print(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 2
+	}
+	if report.Errors > 0 {
+		return 1
+	}
+	return 0
+}
@@ -4,9 +4,16 @@ name="dir2opds"
 # bin
 install -m755 bin/${name} /usr/local/bin/${name}
 
+# config, an existing one is kept
+if [ ! -f /etc/${name}/config.yaml ]; then
+	install -d /etc/${name}
+	install -m644 files/${name}.yaml /etc/${name}/config.yaml
+fi
+
 # rc
 if [ -d /lib/systemd/system/ ]; then
 	install -m644 files/systemd/${name}.service /lib/systemd/system/
+	install -m644 files/systemd/${name}.socket /lib/systemd/system/
 elif [ -d /etc/init.d/ ]; then
 	install -m644 files/init.d/${name} /etc/init.d/
 fi
@@ -5,6 +5,9 @@ go 1.25.3
 require (
 	github.com/lann/builder v0.0.0-20180802200727-47ae307949d0
 	github.com/stretchr/testify v1.11.1
+	golang.org/x/crypto v0.55.0
+	golang.org/x/text v0.41.0
+	gopkg.in/yaml.v3 v3.0.1
 	rsc.io/pdf v0.1.1
 )
 
@@ -12,5 +15,4 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/lann/ps v0.0.0-20150810152359-62de8c46ede0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// anyUser in the allow or deny list of a rule matches every user
+const anyUser = "*"
+
+// ACL decides which users can see which folders. A rule applies to its path and
+// everything under it, and when several rules apply the one with the longest
+// path wins. Paths without a rule are visible to everyone.
+//
+// The paths are the ones of the URLs, so with several libraries they start
+// with the name of the library, e.g. /fiction/private.
+type ACL struct {
+	Groups map[string][]string `yaml:"groups"`
+	Rules  []ACLRule           `yaml:"rules"`
+}
+
+// ACLRule allows or denies a path to users, @groups or * for everyone.
+// A rule with only a deny list allows everyone else.
+type ACLRule struct {
+	Path  string   `yaml:"path"`
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// LoadACL reads the rules of a YAML file
+func LoadACL(name string) (*ACL, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var acl ACL
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&acl); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	seen := make(map[string]bool)
+	for i, rule := range acl.Rules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("%s: rule %d: path %q must start with /", name, i+1, rule.Path)
+		}
+		rule.Path = path.Clean(rule.Path)
+		if seen[rule.Path] {
+			return nil, fmt.Errorf("%s: rule %d: path %s has more than one rule", name, i+1, rule.Path)
+		}
+		seen[rule.Path] = true
+
+		if len(rule.Allow) == 0 && len(rule.Deny) == 0 {
+			return nil, fmt.Errorf("%s: rule %d: path %s needs allow or deny", name, i+1, rule.Path)
+		}
+		for _, subject := range slices.Concat(rule.Allow, rule.Deny) {
+			group, ok := strings.CutPrefix(subject, "@")
+			if _, exists := acl.Groups[group]; ok && !exists {
+				return nil, fmt.Errorf("%s: rule %d: unknown group %s", name, i+1, subject)
+			}
+		}
+		acl.Rules[i] = rule
+	}
+
+	return &acl, nil
+}
+
+// under reports whether p is dir or is inside of it
+func under(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+func (a *ACL) matches(user string, subjects []string) bool {
+	for _, subject := range subjects {
+		if subject == anyUser || subject == user {
+			return true
+		}
+		if group, ok := strings.CutPrefix(subject, "@"); ok {
+			for _, member := range a.Groups[group] {
+				if member == user {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (a *ACL) grants(rule *ACLRule, user string) bool {
+	if a.matches(user, rule.Deny) {
+		return false
+	}
+	return len(rule.Allow) == 0 || a.matches(user, rule.Allow)
+}
+
+// rule returns the rule that applies to p, nil when there is none
+func (a *ACL) rule(p string) *ACLRule {
+	var found *ACLRule
+	for i, rule := range a.Rules {
+		if under(p, rule.Path) && (found == nil || len(rule.Path) > len(found.Path)) {
+			found = &a.Rules[i]
+		}
+	}
+	return found
+}
+
+// Allowed reports whether user can see p and its content. A nil ACL allows everything.
+func (a *ACL) Allowed(user, p string) bool {
+	if a == nil {
+		return true
+	}
+	p = path.Clean("/" + p)
+
+	rule := a.rule(p)
+	return rule == nil || a.grants(rule, user)
+}
+
+// Visible reports whether user can see the folder p, because it is allowed or
+// because it leads to a folder inside of it that is allowed.
+func (a *ACL) Visible(user, p string) bool {
+	if a.Allowed(user, p) {
+		return true
+	}
+	p = path.Clean("/" + p)
+
+	for i, rule := range a.Rules {
+		if rule.Path != p && under(rule.Path, p) && a.grants(&a.Rules[i], user) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed checks the ACL for the user of the request. Folders only need to be
+// visible, their entries are checked one by one.
+func (s OPDS) allowed(urlPath string, dir bool) bool {
+	if s.ACL == nil {
+		return true
+	}
+	p := s.mountPath(urlPath)
+	if dir {
+		return s.ACL.Visible(s.User, p)
+	}
+	return s.ACL.Allowed(s.User, p)
+}
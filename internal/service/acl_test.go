@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeACL(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "acl.yaml")
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+	return p
+}
+
+const testACL = `
+groups:
+  parents: [alice, bob]
+rules:
+  - path: /children
+    allow: ["*"]
+  - path: /private
+    allow: ["@parents"]
+  - path: /private/shared
+    allow: ["@parents", kid]
+  - path: /fiction
+    deny: [kid]
+`
+
+func TestLoadACL(t *testing.T) {
+	acl, err := LoadACL(writeACL(t, testACL))
+	require.NoError(t, err)
+	assert.Len(t, acl.Rules, 4)
+
+	tests := map[string]struct {
+		content string
+		err     string
+	}{
+		"relative path":  {"rules:\n  - path: private\n    allow: [alice]\n", `path "private" must start with /`},
+		"duplicate path": {"rules:\n  - path: /a\n    allow: [alice]\n  - path: /a/\n    deny: [bob]\n", "path /a has more than one rule"},
+		"empty rule":     {"rules:\n  - path: /a\n", "path /a needs allow or deny"},
+		"unknown group":  {"rules:\n  - path: /a\n    allow: ['@staff']\n", "unknown group @staff"},
+		"unknown field":  {"rules:\n  - path: /a\n    readers: [alice]\n", "field readers not found"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadACL(writeACL(t, tc.content))
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+
+	_, err = LoadACL(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestACLAllowed(t *testing.T) {
+	acl, err := LoadACL(writeACL(t, testACL))
+	require.NoError(t, err)
+
+	tests := []struct {
+		user, path       string
+		allowed, visible bool
+	}{
+		{"kid", "/", true, true},
+		{"kid", "/children/book.epub", true, true},
+		{"kid", "/private", false, true},
+		{"kid", "/private/taxes.pdf", false, false},
+		{"kid", "/private/shared/atlas.epub", true, true},
+		{"kid", "/fiction", false, false},
+		{"alice", "/fiction/dune.epub", true, true},
+		{"alice", "/private/taxes.pdf", true, true},
+		{"", "/private", false, false},
+		{"", "/children", true, true},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.allowed, acl.Allowed(tc.user, tc.path), "Allowed(%q, %q)", tc.user, tc.path)
+		assert.Equal(t, tc.visible, acl.Visible(tc.user, tc.path), "Visible(%q, %q)", tc.user, tc.path)
+	}
+
+	var none *ACL
+	assert.True(t, none.Allowed("kid", "/private"))
+	assert.True(t, none.Visible("kid", "/private"))
+}
+
+func TestACLHandlers(t *testing.T) {
+	acl, err := LoadACL(writeACL(t, testACL))
+	require.NoError(t, err)
+
+	modTime := time.Date(2020, 5, 25, 0, 0, 0, 0, time.UTC)
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"children/gruffalo.txt":    {Data: []byte("A mouse took a stroll"), ModTime: modTime},
+			"private/taxes.txt":        {Data: []byte("2019"), ModTime: modTime},
+			"private/shared/atlas.txt": {Data: []byte("Maps"), ModTime: modTime},
+			"fiction/dune.txt":         {Data: []byte("Arrakis"), ModTime: modTime},
+			"fiction/cover.jpg":        {Data: []byte("jpeg"), ModTime: modTime},
+		},
+		EnableSearch: true,
+		ACL:          acl,
+	}
+
+	as := func(req *http.Request, user string) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), userKey{}, user))
+	}
+
+	t.Run("Scan", func(t *testing.T) {
+		s := s
+		s.User = "kid"
+		catalog, err := s.Scan(".", "/", 1)
+		require.NoError(t, err)
+		var names []string
+		for _, entry := range catalog.Entries {
+			names = append(names, entry.Name)
+		}
+		assert.Equal(t, []string{"children", "private"}, names)
+		assert.Equal(t, 2, catalog.Total)
+
+		catalog, err = s.Scan("private", "/private", 1)
+		require.NoError(t, err)
+		require.Len(t, catalog.Entries, 1)
+		assert.Equal(t, "shared", catalog.Entries[0].Name)
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		for path, code := range map[string]int{
+			"/children/gruffalo.txt":    http.StatusOK,
+			"/private/":                 http.StatusOK,
+			"/private/taxes.txt":        http.StatusNotFound,
+			"/private/shared/atlas.txt": http.StatusOK,
+			"/fiction/":                 http.StatusNotFound,
+			"/fiction/dune.txt":         http.StatusNotFound,
+		} {
+			w := httptest.NewRecorder()
+			require.NoError(t, s.Handler(w, as(httptest.NewRequest(http.MethodGet, path, nil), "kid")))
+			assert.Equal(t, code, w.Code, path)
+		}
+
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, as(httptest.NewRequest(http.MethodGet, "/private/taxes.txt", nil), "alice")))
+		assert.Equal(t, "2019", w.Body.String())
+	})
+
+	t.Run("search", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		require.NoError(t, s.SearchHandler(w, as(httptest.NewRequest(http.MethodGet, "/search?q=txt", nil), "kid")))
+		body := w.Body.String()
+		assert.Contains(t, body, `href="/children/gruffalo.txt"`)
+		assert.Contains(t, body, `href="/private/shared/atlas.txt"`)
+		assert.NotContains(t, body, "taxes.txt")
+		assert.NotContains(t, body, "dune.txt")
+	})
+
+	t.Run("cover", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		require.NoError(t, s.CoverHandler(w, as(httptest.NewRequest(http.MethodGet, "/cover?file=/fiction/cover.jpg", nil), "kid")))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("libraries", func(t *testing.T) {
+		l := Libraries{ACL: acl, Libraries: []Library{
+			{Name: "children", OPDS: s},
+			{Name: "fiction", OPDS: s},
+			{Name: "private", OPDS: s},
+		}}
+		var names []string
+		for _, entry := range l.catalog("kid").Entries {
+			names = append(names, entry.Name)
+		}
+		assert.Equal(t, []string{"children", "private"}, names)
+	})
+}
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxMemberBuffer is the biggest compressed archive member that is loaded in memory
+// to extract its metadata. Stored members are read in place whatever their size.
+const maxMemberBuffer = 64 << 20
+
+var errNotInArchive = errors.New("member not found in archive")
+
+// maxMemberMetadata is the number of books kept in memberMetadata
+const maxMemberMetadata = 4096
+
+// memberMetadata caches the metadata of the compressed books of the archives,
+// which are decompressed whole to read it. The books are keyed by their
+// content, so a cached one is right for any archive that holds it.
+var memberMetadata = struct {
+	sync.Mutex
+	books map[memberKey]CatalogEntry
+}{books: make(map[memberKey]CatalogEntry)}
+
+// memberKey tells the books apart by their format, size and CRC-32
+type memberKey struct {
+	ext   string
+	size  uint64
+	crc32 uint32
+}
+
+// archive is a ZIP file browsed as a virtual folder.
+type archive struct {
+	*zip.Reader
+	f       *openedFile
+	members map[string]*zip.File
+}
+
+// hasMetadata reports whether extractMetadata knows how to read the book
+func hasMetadata(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".epub" || ext == ".pdf"
+}
+
+func isArchive(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".zip")
+}
+
+// splitArchivePath splits name in the name of the ZIP archive that holds it
+// and the path of the member inside of it. ok is false when no archive is involved.
+func (s OPDS) splitArchivePath(name string) (archivePath, member string, ok bool) {
+	if !s.BrowseArchives || name == currentDirectory {
+		return "", "", false
+	}
+
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if !isArchive(part) {
+			continue
+		}
+
+		candidate := path.Join(parts[:i+1]...)
+		info, err := fs.Stat(s.storage(), candidate)
+		if err != nil {
+			return "", "", false
+		}
+		if info.Mode().IsRegular() {
+			member = path.Join(parts[i+1:]...)
+			if member == "" {
+				member = currentDirectory
+			}
+			return candidate, member, true
+		}
+	}
+
+	return "", "", false
+}
+
+func openArchive(fsys fs.FS, name string) (*archive, error) {
+	f, err := openFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(f, f.info.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("opening archive: %w", err)
+	}
+
+	a := &archive{Reader: zr, f: f, members: make(map[string]*zip.File, len(zr.File))}
+	for _, zf := range zr.File {
+		a.members[path.Clean(zf.Name)] = zf
+	}
+	return a, nil
+}
+
+// openArchiveMember opens the archive and looks up the file member in it.
+func (s OPDS) openArchiveMember(archivePath, member string) (*archive, *zip.File, error) {
+	a, err := openArchive(s.storage(), archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, ok := a.members[member]
+	if !ok || !fs.ValidPath(member) || f.FileInfo().IsDir() {
+		a.Close()
+		return nil, nil, errNotInArchive
+	}
+	return a, f, nil
+}
+
+func (a *archive) Close() error {
+	return a.f.Close()
+}
+
+// memberReader gives random access to a member. Stored members are read in place,
+// compressed ones are decompressed in memory up to maxMemberBuffer.
+func (a *archive) memberReader(f *zip.File) (readSeekerAt, error) {
+	if f.Method == zip.Store {
+		offset, err := f.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		return io.NewSectionReader(a.f, offset, int64(f.UncompressedSize64)), nil
+	}
+
+	if f.UncompressedSize64 > maxMemberBuffer {
+		return nil, fmt.Errorf("member %s is too big to be read in memory", f.Name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// metadata returns the metadata of the book f in the fields of a
+// CatalogEntry. The one of the compressed books comes from memberMetadata.
+func (a *archive) metadata(f *zip.File) (CatalogEntry, error) {
+	key := memberKey{ext: strings.ToLower(path.Ext(f.Name)), size: f.UncompressedSize64, crc32: f.CRC32}
+	cached := f.Method != zip.Store
+	if cached {
+		memberMetadata.Lock()
+		book, ok := memberMetadata.books[key]
+		memberMetadata.Unlock()
+		if ok {
+			return book, nil
+		}
+	}
+
+	r, err := a.memberReader(f)
+	if err != nil {
+		return CatalogEntry{}, err
+	}
+	var book CatalogEntry
+	book.setMetadata(extractMetadataFrom(f.Name, r, int64(f.UncompressedSize64)))
+
+	if cached {
+		memberMetadata.Lock()
+		if len(memberMetadata.books) >= maxMemberMetadata {
+			clear(memberMetadata.books)
+		}
+		memberMetadata.books[key] = book
+		memberMetadata.Unlock()
+	}
+	return book, nil
+}
+
+func (a *archive) epubCover(f *zip.File) ([]byte, string, error) {
+	r, err := a.memberReader(f)
+	if err != nil {
+		return nil, "", err
+	}
+
+	zr, err := zip.NewReader(r, int64(f.UncompressedSize64))
+	if err != nil {
+		return nil, "", fmt.Errorf("opening epub: %w", err)
+	}
+	return extractEpubCoverFrom(zr)
+}
+
+// pathType classifies a member like getPathType does for real folders
+func (a *archive) pathType(member string) int {
+	entries, err := fs.ReadDir(a, member)
+	if err != nil {
+		return pathTypeFile
+	}
+
+	for _, entry := range entries {
+		if isFile(entry) && !strings.HasPrefix(entry.Name(), hiddenFilePrefix) {
+			return pathTypeDirOfFiles
+		}
+	}
+	return pathTypeDirOfDirs
+}
+
+// scanArchive builds the Catalog of a folder inside of an archive
+func (s OPDS) scanArchive(archivePath, member, urlPath string, page int) (*Catalog, error) {
+	a, err := openArchive(s.storage(), archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+
+	dirEntries, err := fs.ReadDir(a, member)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &Catalog{
+		ID:      s.mountPath(urlPath),
+		Title:   "Catalog in " + s.mountPath(urlPath),
+		Type:    a.pathType(member),
+		ModTime: a.f.info.ModTime(),
+	}
+
+	// the members are matched with their path in the storage, like books.zip/extras/notes.txt
+	rules := s.ignoreRules(path.Dir(archivePath))
+	for _, entry := range dirEntries {
+		if fileShouldBeIgnored(entry.Name(), s.HideCalibreFiles, s.HideDotFiles) ||
+			rules.match(path.Join(archivePath, member, entry.Name()), entry.IsDir()) {
+			continue
+		}
+
+		if s.ShowCovers && (entry.Name() == "cover.jpg" || entry.Name() == "folder.jpg") {
+			catalog.Cover = path.Join(urlPath, entry.Name())
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			slog.Error("error getting info for archive entry", "error", err)
+			continue
+		}
+
+		entryMember := path.Join(member, entry.Name())
+		entryType := pathTypeFile
+		if entry.IsDir() {
+			entryType = a.pathType(entryMember)
+		}
+		if !s.allowed(path.Join(urlPath, entry.Name()), entryType != pathTypeFile) {
+			continue
+		}
+
+		catalog.Entries = append(catalog.Entries, CatalogEntry{
+			Name:    entry.Name(),
+			Type:    entryType,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		})
+
+		if info.ModTime().After(catalog.ModTime) {
+			catalog.ModTime = info.ModTime()
+		}
+
+		if s.ExtractMetadata && entryType == pathTypeFile && hasMetadata(entry.Name()) {
+			f, ok := a.members[entryMember]
+			if !ok {
+				continue
+			}
+			book, err := a.metadata(f)
+			if err != nil {
+				slog.Error("error reading archive member", "name", f.Name, "error", err)
+				continue
+			}
+			idx := len(catalog.Entries) - 1
+			catalog.Entries[idx].setMetadata(book.Title, book.Author, book.CoverPath, book.Description, book.Series, book.SeriesIndex, book.Language, book.Subjects)
+		}
+	}
+
+	s.paginate(catalog, page)
+
+	return catalog, nil
+}
+
+// archiveHandler serves a member of an archive, or the feed of a folder inside of it
+func (s OPDS) archiveHandler(w http.ResponseWriter, req *http.Request, name, urlPath, archivePath, member string) error {
+	a, err := openArchive(s.storage(), archivePath)
+	if err != nil {
+		slog.Error("error opening archive", "path", archivePath, "error", err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	defer a.Close()
+
+	info, err := fs.Stat(a, member)
+	if err != nil || !s.allowed(urlPath, info.IsDir()) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	if info.IsDir() {
+		return s.serveCatalog(w, req, name, urlPath)
+	}
+
+	f, ok := a.members[member]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	countDownload(req, f.Name)
+	contentType := s.getType(f.Name, pathTypeFile)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	// stored members can be read in place, so ranges keep working
+	if f.Method == zip.Store {
+		r, err := a.memberReader(f)
+		if err != nil {
+			return err
+		}
+		http.ServeContent(w, req, f.Name, f.Modified, r)
+		return nil
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Length", strconv.FormatUint(f.UncompressedSize64, 10))
+	w.Header().Set("Last-Modified", f.Modified.UTC().Format(http.TimeFormat))
+	if _, err := io.Copy(w, rc); err != nil {
+		// the headers are already sent, the only option left is to cut the stream.
+		slog.Error("error streaming archive member", "name", f.Name, "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"archive/zip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestArchive creates bundle.zip in dir with a deflated EPUB in a subfolder
+// and a stored text file at the top level.
+func writeTestArchive(t *testing.T, dir string) {
+	t.Helper()
+
+	epub, err := os.ReadFile(filepath.Join("testdata", "mybook", "mybook.epub"))
+	require.NoError(t, err)
+
+	f, err := os.Create(filepath.Join(dir, "bundle.zip"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "books/mybook.epub", Method: zip.Deflate})
+	require.NoError(t, err)
+	_, err = w.Write(epub)
+	require.NoError(t, err)
+
+	w, err = zw.CreateHeader(&zip.FileHeader{Name: "notes.txt", Method: zip.Store})
+	require.NoError(t, err)
+	_, err = w.Write([]byte("Fixture"))
+	require.NoError(t, err)
+
+	require.NoError(t, zw.Close())
+}
+
+func TestArchiveBrowsing(t *testing.T) {
+	root := t.TempDir()
+	writeTestArchive(t, root)
+
+	s := OPDS{
+		TrustedRoot:      root,
+		HideCalibreFiles: true,
+		HideDotFiles:     true,
+		ExtractMetadata:  true,
+		BrowseArchives:   true,
+	}
+
+	serve := func(t *testing.T, target string, header ...string) (*http.Response, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		require.NoError(t, s.Handler(rec, req))
+		resp := rec.Result()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	t.Run("archive is listed as a folder", func(t *testing.T) {
+		resp, body := serve(t, "/")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, body, `<link rel="subsection" href="/bundle.zip" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="bundle.zip">`)
+	})
+
+	t.Run("archive members", func(t *testing.T) {
+		resp, body := serve(t, "/bundle.zip")
+		assert.Equal(t, "application/atom+xml;profile=opds-catalog;kind=acquisition", resp.Header.Get("Content-Type"))
+		assert.Contains(t, body, `href="/bundle.zip/books"`)
+		assert.Contains(t, body, `href="/bundle.zip/notes.txt"`)
+	})
+
+	t.Run("folder inside the archive", func(t *testing.T) {
+		resp, body := serve(t, "/bundle.zip/books")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, body, `href="/bundle.zip/books/mybook.epub"`)
+	})
+
+	t.Run("stored member supports ranges", func(t *testing.T) {
+		resp, body := serve(t, "/bundle.zip/notes.txt", "Range", "bytes=0-3")
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "Fixt", body)
+	})
+
+	t.Run("compressed member is streamed", func(t *testing.T) {
+		want, err := os.ReadFile(filepath.Join("testdata", "mybook", "mybook.epub"))
+		require.NoError(t, err)
+
+		resp, body := serve(t, "/bundle.zip/books/mybook.epub")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/epub+zip", resp.Header.Get("Content-Type"))
+		assert.Equal(t, string(want), body)
+	})
+
+	t.Run("metadata of compressed members is cached", func(t *testing.T) {
+		a, err := openArchive(s.storage(), "bundle.zip")
+		require.NoError(t, err)
+		defer a.Close()
+		f := a.members["books/mybook.epub"]
+		key := memberKey{ext: ".epub", size: f.UncompressedSize64, crc32: f.CRC32}
+
+		_, body := serve(t, "/bundle.zip/books")
+		memberMetadata.Lock()
+		book, ok := memberMetadata.books[key]
+		require.True(t, ok)
+		assert.Contains(t, body, "<title>"+book.Title+"</title>")
+
+		// the next listings don't decompress it again
+		book.Title = "From the cache"
+		memberMetadata.books[key] = book
+		memberMetadata.Unlock()
+		_, body = serve(t, "/bundle.zip/books")
+		assert.Contains(t, body, "<title>From the cache</title>")
+
+		memberMetadata.Lock()
+		delete(memberMetadata.books, key)
+		memberMetadata.Unlock()
+	})
+
+	t.Run("missing member", func(t *testing.T) {
+		resp, _ := serve(t, "/bundle.zip/missing.epub")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("path traversal", func(t *testing.T) {
+		resp, _ := serve(t, "/bundle.zip/../../../etc/passwd")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		disabled := s
+		disabled.BrowseArchives = false
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/bundle.zip/notes.txt", nil)
+		require.NoError(t, disabled.Handler(rec, req))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestSplitArchivePath(t *testing.T) {
+	root := t.TempDir()
+	writeTestArchive(t, root)
+	s := OPDS{TrustedRoot: root, BrowseArchives: true}
+
+	archivePath, member, ok := s.splitArchivePath("bundle.zip/books/mybook.epub")
+	assert.True(t, ok)
+	assert.Equal(t, "bundle.zip", archivePath)
+	assert.Equal(t, "books/mybook.epub", member)
+
+	_, member, ok = s.splitArchivePath("bundle.zip")
+	assert.True(t, ok)
+	assert.Equal(t, ".", member)
+
+	_, _, ok = s.splitArchivePath(".")
+	assert.False(t, ok)
+
+	_, _, ok = s.splitArchivePath("other.zip/book.epub")
+	assert.False(t, ok)
+}
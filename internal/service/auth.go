@@ -0,0 +1,163 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// AuthDocumentPath is where the OPDS Authentication Document is served
+	AuthDocumentPath = "/authentication.json"
+
+	authDocumentType = "application/opds-authentication+json"
+	authDocumentRel  = "http://opds-spec.org/auth/document"
+	authRealm        = "dir2opds"
+)
+
+type userKey struct{}
+
+// UserFromContext returns the user authenticated by BasicAuth, empty when there is none
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// dummyHash is compared for unknown users so they take as long as known ones
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte(authRealm), bcrypt.DefaultCost)
+	return hash
+})
+
+// Users holds the bcrypt password hashes by user name
+type Users map[string][]byte
+
+// LoadHtpasswd reads an htpasswd file with bcrypt hashes, as made by htpasswd -B
+func LoadHtpasswd(name string) (Users, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(Users)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", name, n)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: user %s: only bcrypt hashes are supported, use htpasswd -B", name, n, user)
+		}
+		users[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// BasicAuth asks for the credentials of Users in every request but the ones to
+// the Public paths, like /health, and the OPDS Authentication Document.
+type BasicAuth struct {
+	Users   Users
+	BaseURL string
+	Public  []string
+	// Signer accepts the signed URLs of the feeds instead of the credentials
+	Signer *URLSigner
+}
+
+// Middleware checks the credentials before calling next. bcrypt is slow on
+// purpose, so the credentials that were already verified are remembered.
+func (a BasicAuth) Middleware(next http.Handler) http.Handler {
+	var verified sync.Map
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == AuthDocumentPath {
+			a.DocumentHandler(w, r)
+			return
+		}
+		if slices.Contains(a.Public, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.Signer != nil && r.URL.Query().Has(signatureParam) && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			if user, ok := a.Signer.Verify(r.URL); ok {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+				return
+			}
+		}
+
+		user, password, ok := r.BasicAuth()
+		if ok {
+			key := sha256.Sum256([]byte(user + "\x00" + password))
+			if _, cached := verified.Load(key); cached || a.verify(user, password) {
+				verified.Store(key, struct{}{})
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+authRealm+`", charset="UTF-8"`)
+		w.Header().Set("Link", "<"+joinBaseURL(a.BaseURL, AuthDocumentPath)+`>; rel="`+authDocumentRel+`"; type="`+authDocumentType+`"`)
+		w.Header().Set("Content-Type", authDocumentType)
+		w.WriteHeader(http.StatusUnauthorized)
+		a.writeDocument(w)
+	})
+}
+
+func (a BasicAuth) verify(user, password string) bool {
+	hash, ok := a.Users[user]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+type authDocument struct {
+	ID             string               `json:"id"`
+	Title          string               `json:"title"`
+	Description    string               `json:"description,omitempty"`
+	Authentication []authenticationFlow `json:"authentication"`
+}
+
+type authenticationFlow struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// DocumentHandler serves the OPDS Authentication Document, see
+// https://drafts.opds.io/authentication-for-opds-1.0
+func (a BasicAuth) DocumentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", authDocumentType)
+	a.writeDocument(w)
+}
+
+func (a BasicAuth) writeDocument(w http.ResponseWriter) {
+	_ = json.NewEncoder(w).Encode(authDocument{
+		ID:          joinBaseURL(a.BaseURL, AuthDocumentPath),
+		Title:       authRealm,
+		Description: "Sign in to browse and download the books",
+		Authentication: []authenticationFlow{{
+			Type:   "http://opds-spec.org/auth/basic",
+			Labels: map[string]string{"login": "User", "password": "Password"},
+		}},
+	})
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeHtpasswd(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+	return p
+}
+
+func testUsers(t *testing.T) Users {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	users, err := LoadHtpasswd(writeHtpasswd(t, "# readers\nalice:"+string(hash)+"\n\n"))
+	require.NoError(t, err)
+	return users
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	users := testUsers(t)
+	assert.Len(t, users, 1)
+	assert.Contains(t, users, "alice")
+
+	_, err := LoadHtpasswd(writeHtpasswd(t, "bob:{SHA}fEqNCco3Yq9h5ZUglD3CZJT4lBs=\n"))
+	assert.ErrorContains(t, err, "only bcrypt hashes are supported")
+
+	_, err = LoadHtpasswd(writeHtpasswd(t, "bob\n"))
+	assert.ErrorContains(t, err, ":1: expected user:hash")
+
+	_, err = LoadHtpasswd(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestBasicAuthMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "catalog")
+	})
+	h := BasicAuth{
+		Users:   testUsers(t),
+		BaseURL: "https://opds.example.com",
+		Public:  []string{"/health"},
+	}.Middleware(next)
+
+	request := func(path, user, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("no credentials", func(t *testing.T) {
+		rec := request("/", "", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, `Basic realm="dir2opds", charset="UTF-8"`, rec.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, "application/opds-authentication+json", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Header().Get("Link"), `<https://opds.example.com/authentication.json>; rel="http://opds-spec.org/auth/document"`)
+
+		var doc authDocument
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+		assert.Equal(t, "https://opds.example.com/authentication.json", doc.ID)
+		require.Len(t, doc.Authentication, 1)
+		assert.Equal(t, "http://opds-spec.org/auth/basic", doc.Authentication[0].Type)
+	})
+
+	t.Run("valid credentials", func(t *testing.T) {
+		for range 2 {
+			rec := request("/mybook", "alice", "secret")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "catalog", rec.Body.String())
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request("/", "alice", "guess").Code)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request("/", "mallory", "secret").Code)
+	})
+
+	t.Run("public paths", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request("/health", "", "").Code)
+
+		rec := request(AuthDocumentPath, "", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/opds-authentication+json", rec.Header().Get("Content-Type"))
+	})
+}
+
+func TestFeedAuthDocumentLink(t *testing.T) {
+	s := OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, HideDotFiles: true, EnableAuth: true}
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, s.Handler(rec, httptest.NewRequest(http.MethodGet, "/", nil)))
+	assert.Contains(t, rec.Body.String(), `<link rel="http://opds-spec.org/auth/document" href="/authentication.json" type="application/opds-authentication+json"></link>`)
+}
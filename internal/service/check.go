@@ -0,0 +1,168 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"rsc.io/pdf"
+)
+
+// The kinds of the problems found by Check
+const (
+	// ProblemUnreadable is a file or folder that can't be read
+	ProblemUnreadable = "unreadable"
+	// ProblemBrokenLink is a symlink to nothing or to outside of the library
+	ProblemBrokenLink = "broken-link"
+	// ProblemCorruptZip is an EPUB, CBZ or ZIP file that is not a valid ZIP
+	// archive or has a member that can't be extracted
+	ProblemCorruptZip = "corrupt-zip"
+	// ProblemNoOPF is an EPUB without the .opf file with its metadata
+	ProblemNoOPF = "epub-no-opf"
+	// ProblemBadOPF is an EPUB with an .opf file that is not valid XML
+	ProblemBadOPF = "epub-bad-opf"
+	// ProblemBadPDF is a PDF that can't be opened
+	ProblemBadPDF = "bad-pdf"
+	// ProblemUnknownType is a file served without a MIME type
+	ProblemUnknownType = "unknown-type"
+)
+
+// CheckReport lists the problems of the files of a library
+type CheckReport struct {
+	Files    int       `json:"files"`
+	Problems []Problem `json:"problems"`
+}
+
+// Problem is something wrong with a file, Path is its URL path
+type Problem struct {
+	Path  string `json:"path"`
+	Kind  string `json:"kind"`
+	Error string `json:"error"`
+}
+
+// Print writes the report for people, a line per problem
+func (r CheckReport) Print(w io.Writer) error {
+	for _, p := range r.Problems {
+		fmt.Fprintf(w, "%s: %s: %s\n", p.Path, p.Kind, p.Error)
+	}
+	_, err := fmt.Fprintf(w, "%d files checked, %d problems\n", r.Files, len(r.Problems))
+	return err
+}
+
+// Check walks the whole library and reads every file that is served, like the
+// feeds and the metadata extraction do, reporting the errors they skip.
+func (s OPDS) Check() CheckReport {
+	report := CheckReport{Problems: []Problem{}}
+	problem := func(name, kind string, err error) {
+		urlPath := "/"
+		if name != currentDirectory {
+			urlPath += name
+		}
+		report.Problems = append(report.Problems, Problem{
+			Path:  s.mountPath(urlPath),
+			Kind:  kind,
+			Error: err.Error(),
+		})
+	}
+
+	fsys := s.storage()
+	walker := s.newIgnoreWalker(currentDirectory)
+	_ = fs.WalkDir(fsys, currentDirectory, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			problem(name, ProblemUnreadable, err)
+			return nil
+		}
+		if name != currentDirectory && fileShouldBeIgnored(d.Name(), s.HideCalibreFiles, s.HideDotFiles) || walker.ignored(name, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			info, err := fs.Stat(fsys, name)
+			if err != nil {
+				problem(name, ProblemBrokenLink, errors.New("the symlink points to nothing or to outside of the library"))
+				return nil
+			}
+			if info.IsDir() {
+				return nil
+			}
+		}
+
+		report.Files++
+		if kind, err := s.checkFile(fsys, name); err != nil {
+			problem(name, kind, err)
+		}
+		return nil
+	})
+	return report
+}
+
+// checkFile reads the file name whole and returns the kind of its first problem
+func (s OPDS) checkFile(fsys fs.FS, name string) (string, error) {
+	if s.getType(name, pathTypeFile) == "" {
+		return ProblemUnknownType, fmt.Errorf("no MIME type for %q, set one with -mime-map", path.Ext(name))
+	}
+
+	f, err := openFile(fsys, name)
+	if err != nil {
+		return ProblemUnreadable, err
+	}
+	defer f.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".epub", ".cbz", ".zip":
+		r, err := zip.NewReader(f, f.info.Size())
+		if err != nil {
+			return ProblemCorruptZip, err
+		}
+		for _, member := range r.File {
+			if err := readMember(member); err != nil {
+				return ProblemCorruptZip, fmt.Errorf("%s: %w", member.Name, err)
+			}
+		}
+		if ext != ".epub" {
+			return "", nil
+		}
+
+		_, content, err := epubOPF(r)
+		if err != nil {
+			return ProblemNoOPF, err
+		}
+		if err := xml.NewDecoder(bytes.NewReader(content)).Decode(new(struct{})); err != nil {
+			return ProblemBadOPF, err
+		}
+		return "", nil
+	case ".pdf":
+		if _, err := pdf.NewReader(f, f.info.Size()); err != nil {
+			return ProblemBadPDF, err
+		}
+	}
+
+	if _, err := io.Copy(io.Discard, f); err != nil {
+		return ProblemUnreadable, err
+	}
+	return "", nil
+}
+
+// readMember reads a member of a ZIP archive to the end, which checks its checksum
+func readMember(member *zip.File) error {
+	rc, err := member.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
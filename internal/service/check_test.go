@@ -0,0 +1,98 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testZip returns a ZIP archive with the files of members, name and content,
+// stored without compression
+func testZip(t *testing.T, members ...string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < len(members); i += 2 {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: members[i], Method: zip.Store})
+		require.NoError(t, err)
+		_, err = w.Write([]byte(members[i+1]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestCheck(t *testing.T) {
+	// a member stored without compression whose content no longer matches its checksum
+	damaged := testZip(t, "page1.jpg", "original content")
+	damaged = bytes.Replace(damaged, []byte("original"), []byte("modified"), 1)
+
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"good/Dune.epub":        {Data: testEpubMetadata(t, `<dc:title>Dune</dc:title>`)},
+			"good/Dune.txt":         {Data: []byte("dune")},
+			"good/cover.jpg":        {Data: []byte("jpg")},
+			"bad/truncated.epub":    {Data: testEpubMetadata(t, `<dc:title>Dune</dc:title>`)[:40]},
+			"bad/no-opf.epub":       {Data: testZip(t, "mimetype", "application/epub+zip")},
+			"bad/broken-opf.epub":   {Data: testZip(t, "content.opf", "<package><metadata></package>")},
+			"bad/scan.pdf":          {Data: []byte("%PDF-1.4 not really")},
+			"bad/notes":             {Data: []byte("notes")},
+			"bad/damaged.cbz":       {Data: damaged},
+			"ignored/.opdsignore":   {Data: []byte("*\n")},
+			"ignored/broken.epub":   {Data: []byte("ignored")},
+			".hidden/broken.epub":   {Data: []byte("hidden")},
+			"mapped/Book.kepub":     {Data: []byte("kepub")},
+			"mapped/Book.something": {Data: []byte("unknown")},
+		},
+		HideDotFiles: true,
+		MimeMap:      map[string]string{".kepub": "application/kepub+zip"},
+	}
+
+	report := s.Check()
+	assert.Equal(t, 11, report.Files)
+
+	problems := map[string]string{}
+	for _, p := range report.Problems {
+		problems[p.Path] = p.Kind
+		assert.NotEmpty(t, p.Error, p.Path)
+	}
+	assert.Equal(t, map[string]string{
+		"/bad/truncated.epub":    ProblemCorruptZip,
+		"/bad/no-opf.epub":       ProblemNoOPF,
+		"/bad/broken-opf.epub":   ProblemBadOPF,
+		"/bad/scan.pdf":          ProblemBadPDF,
+		"/bad/notes":             ProblemUnknownType,
+		"/bad/damaged.cbz":       ProblemCorruptZip,
+		"/mapped/Book.something": ProblemUnknownType,
+	}, problems)
+
+	var out bytes.Buffer
+	require.NoError(t, report.Print(&out))
+	assert.Contains(t, out.String(), "/bad/no-opf.epub: epub-no-opf: no .opf file\n")
+	assert.True(t, strings.HasSuffix(out.String(), "11 files checked, 7 problems\n"))
+
+	t.Run("broken symlinks", func(t *testing.T) {
+		root := t.TempDir()
+		outside := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "book.epub"), testEpubMetadata(t, ""), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644))
+		require.NoError(t, os.Symlink(filepath.Join(root, "missing.epub"), filepath.Join(root, "dangling.epub")))
+		require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret.txt")))
+		require.NoError(t, os.Symlink(filepath.Join(root, "book.epub"), filepath.Join(root, "link.epub")))
+
+		report := OPDS{TrustedRoot: root, Prefix: "/fiction"}.Check()
+		assert.Equal(t, 2, report.Files)
+		assert.ElementsMatch(t, []Problem{
+			{Path: "/fiction/dangling.epub", Kind: ProblemBrokenLink, Error: "the symlink points to nothing or to outside of the library"},
+			{Path: "/fiction/secret.txt", Kind: ProblemBrokenLink, Error: "the symlink points to nothing or to outside of the library"},
+		}, report.Problems)
+	})
+}
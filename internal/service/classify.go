@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// classifyBatch is the number of entries read at a time to classify a folder,
+// the first ones usually tell what it holds.
+const classifyBatch = 32
+
+// folderClassifier tells a folder of books from a folder of folders by its
+// entries, it is done as soon as the ones seen decide.
+type folderClassifier struct {
+	name string
+	// rules are the ones of the folder that holds name
+	rules ignoreRules
+	// archives are folders when they are browsed
+	archives bool
+	// split makes a folder with books and folders a folder of folders
+	split bool
+
+	books, folders bool
+	// listed is set once all the entries were added, config when one of them
+	// is the sidecar file
+	listed, config bool
+}
+
+// add looks at more entries of the folder and reports whether it is done
+func (c *folderClassifier) add(entries []fs.DirEntry) bool {
+	for _, entry := range entries {
+		if entry.Name() == folderConfigName {
+			c.config = true
+		}
+		if strings.HasPrefix(entry.Name(), hiddenFilePrefix) ||
+			c.rules.match(path.Join(c.name, entry.Name()), entry.IsDir()) {
+			continue
+		}
+		if entry.IsDir() || c.archives && isArchive(entry.Name()) {
+			c.folders = true
+		} else {
+			c.books = true
+		}
+	}
+	return c.books && (!c.split || c.folders)
+}
+
+// pathType returns the type of the folder from the entries added so far
+func (c *folderClassifier) pathType() int {
+	if c.books && !(c.split && c.folders) {
+		return pathTypeDirOfFiles
+	}
+	return pathTypeDirOfDirs
+}
+
+// classify reads the folder until its type is known, instead of listing all of
+// it. The rules of its .opdsignore file are read first when it takes more than
+// one batch, since its entries come in no particular order.
+func (c *folderClassifier) classify(fsys fs.FS) int {
+	f, err := fsys.Open(c.name)
+	if err != nil {
+		slog.Error("getPathType open error", "error", err)
+		return pathTypeFile
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		slog.Error("getPathType stat error", "error", err)
+		return pathTypeFile
+	}
+	if isFile(info) {
+		return pathTypeFile
+	}
+
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		dirEntries, err := fs.ReadDir(fsys, c.name)
+		if err != nil {
+			slog.Error("getPathType: readDir error", "error", err)
+		}
+		c.rules = c.rules.readFrom(fsys, c.name, dirEntries)
+		c.add(dirEntries)
+		c.listed = true
+		return c.pathType()
+	}
+
+	for first := true; ; first = false {
+		dirEntries, err := dir.ReadDir(classifyBatch)
+		c.listed = len(dirEntries) < classifyBatch || err != nil
+		if first {
+			if len(dirEntries) < classifyBatch {
+				c.rules = c.rules.readFrom(fsys, c.name, dirEntries)
+			} else {
+				c.rules = c.rules.read(fsys, c.name)
+			}
+		}
+		if c.add(dirEntries) {
+			break
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				slog.Error("getPathType: readDir error", "error", err)
+			}
+			break
+		}
+	}
+	return c.pathType()
+}
+
+// pathType returns the type a folder is presented as, aware of the archives
+// browsed as folders, and of the mixed folders when they are split
+func (s OPDS) pathType(name string, rules ignoreRules) int {
+	fsys := s.storage()
+	if s.BrowseArchives && isArchive(name) {
+		if info, err := fs.Stat(fsys, name); err == nil && info.Mode().IsRegular() {
+			a, err := openArchive(fsys, name)
+			if err != nil {
+				slog.Error("pathType: open archive error", "error", err)
+				return pathTypeFile
+			}
+			defer a.Close()
+			return a.pathType(currentDirectory)
+		}
+	}
+
+	c := folderClassifier{name: name, rules: rules, archives: s.BrowseArchives, split: s.splitMixed()}
+	return c.classify(fsys)
+}
+
+// classify sets the types of the folders on the page of the catalog, with the
+// titles, descriptions and covers of their sidecar files. The folders are
+// only read once they are on the page, configs has the sidecar files already
+// read to sort the entries.
+func (s OPDS) classify(catalog *Catalog, name string, rules ignoreRules, configs map[string]folderConfig) {
+	fsys := s.storage()
+	for i, entry := range catalog.Entries {
+		if entry.Type == pathTypeFile {
+			continue
+		}
+
+		entryPath := path.Join(name, entry.Name)
+		sub, ok := configs[entry.Name]
+		entryType, classified := 0, false
+		if !ok && !(s.BrowseArchives && isArchive(entry.Name)) {
+			// most folders have no sidecar file, there is no need to look for
+			// it in the ones listed whole to classify them
+			c := folderClassifier{name: entryPath, rules: rules, archives: s.BrowseArchives, split: s.splitMixed()}
+			entryType, classified = c.classify(fsys), true
+			if c.listed && !c.config {
+				catalog.Entries[i].Type = entryType
+				continue
+			}
+		}
+		if !ok {
+			sub = s.readFolderConfig(entryPath)
+		}
+
+		switch {
+		case sub.Kind != "":
+			entry.Type = sub.pathType(entry.Type)
+		case classified && s.withFolder(sub).splitMixed() == s.splitMixed():
+			// the folder is classified again only when it splits otherwise
+			entry.Type = entryType
+		default:
+			entry.Type = s.withFolder(sub).pathType(entryPath, rules)
+		}
+		entry.Title = sub.Title
+		entry.Description = sub.Description
+		entry.CoverPath = sub.Cover
+		catalog.Entries[i] = entry
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeWideTree creates authors folders with books books each under root,
+// and an author with a folder per book
+func writeWideTree(tb testing.TB, root string, authors, books int) {
+	tb.Helper()
+	for i := range authors {
+		dir := filepath.Join(root, fmt.Sprintf("Author %03d", i))
+		require.NoError(tb, os.MkdirAll(dir, 0o755))
+		for j := range books {
+			require.NoError(tb, os.WriteFile(filepath.Join(dir, fmt.Sprintf("Book %02d.epub", j)), []byte("epub"), 0o644))
+		}
+	}
+	for j := range books {
+		require.NoError(tb, os.MkdirAll(filepath.Join(root, "Anthologies", fmt.Sprintf("Volume %02d", j)), 0o755))
+	}
+}
+
+func TestClassify(t *testing.T) {
+	fsys := fstest.MapFS{
+		"books/a.epub":            {Data: []byte("a")},
+		"folders/a/b.epub":        {Data: []byte("b")},
+		"hidden/.a.epub":          {Data: []byte("a")},
+		"hidden/x/b.epub":         {Data: []byte("b")},
+		"mixed/a.epub":            {Data: []byte("a")},
+		"mixed/x/b.epub":          {Data: []byte("b")},
+		"archives/a.zip":          {Data: []byte("zip")},
+		"ignored/.opdsignore":     {Data: []byte("*.txt\n")},
+		"ignored/notes.txt":       {Data: []byte("txt")},
+		"ignored/x/b.epub":        {Data: []byte("b")},
+		"file.epub":               {Data: []byte("f")},
+		"empty/.keep":             {Data: []byte("")},
+		"many/00/a.epub":          {Data: []byte("a")},
+		"many/zz-the-only-a.epub": {Data: []byte("a")},
+		"scans/.opdsignore":       {Data: []byte("*.txt\n")},
+		"scans/x/a.epub":          {Data: []byte("a")},
+	}
+	for i := range 2 * classifyBatch {
+		fsys[fmt.Sprintf("many/%02d/a.epub", i)] = &fstest.MapFile{Data: []byte("a")}
+		// a folder read in batches still follows its .opdsignore file
+		fsys[fmt.Sprintf("scans/%02d.txt", i)] = &fstest.MapFile{Data: []byte("txt")}
+	}
+
+	for name, want := range map[string]int{
+		"books":     pathTypeDirOfFiles,
+		"folders":   pathTypeDirOfDirs,
+		"hidden":    pathTypeDirOfDirs,
+		"mixed":     pathTypeDirOfFiles,
+		"archives":  pathTypeDirOfFiles,
+		"ignored":   pathTypeDirOfDirs,
+		"file.epub": pathTypeFile,
+		"empty":     pathTypeDirOfDirs,
+		"many":      pathTypeDirOfFiles,
+		"scans":     pathTypeDirOfDirs,
+		"missing":   pathTypeFile,
+	} {
+		assert.Equal(t, want, getPathType(fsys, name, nil), name)
+	}
+
+	s := OPDS{Storage: fsys, BrowseArchives: true, MixedFolders: MixedSplit}
+	assert.Equal(t, pathTypeDirOfDirs, s.pathType("archives", nil), "the archives are folders")
+	assert.Equal(t, pathTypeDirOfDirs, s.pathType("mixed", nil))
+	assert.Equal(t, pathTypeDirOfFiles, s.pathType("books", nil))
+
+	t.Run("scan classifies the page", func(t *testing.T) {
+		root := t.TempDir()
+		writeWideTree(t, root, 60, 2)
+		s := OPDS{TrustedRoot: root, PageSize: 50}
+
+		catalog, err := s.Scan(currentDirectory, "/", 1)
+		require.NoError(t, err)
+		assert.Equal(t, pathTypeDirOfDirs, catalog.Type)
+		assert.Equal(t, 61, catalog.Total)
+		require.Len(t, catalog.Entries, 50)
+		assert.Equal(t, "Anthologies", catalog.Entries[0].Name)
+		assert.Equal(t, pathTypeDirOfDirs, catalog.Entries[0].Type)
+		assert.Equal(t, pathTypeDirOfFiles, catalog.Entries[1].Type)
+
+		catalog, err = s.Scan(currentDirectory, "/", 2)
+		require.NoError(t, err)
+		require.Len(t, catalog.Entries, 11)
+		assert.Equal(t, pathTypeDirOfFiles, catalog.Entries[10].Type)
+	})
+
+	t.Run("sidecar of a folder read in batches", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"wide/.dir2opds.yaml":  {Data: []byte("title: Wide\n")},
+			"split/.dir2opds.yaml": {Data: []byte("mixed: split\n")},
+			"split/x/a.epub":       {Data: []byte("a")},
+		}
+		for i := range 2 * classifyBatch {
+			fsys[fmt.Sprintf("wide/%02d.epub", i)] = &fstest.MapFile{Data: []byte("a")}
+			fsys[fmt.Sprintf("split/%02d.epub", i)] = &fstest.MapFile{Data: []byte("a")}
+		}
+		opens := openCounter{FS: fsys, opens: make(map[string]int)}
+		s := OPDS{Storage: opens}
+
+		catalog, err := s.Scan(currentDirectory, "/", 1)
+		require.NoError(t, err)
+		require.Len(t, catalog.Entries, 2)
+		assert.Equal(t, "split", catalog.Entries[0].Name)
+		assert.Equal(t, pathTypeDirOfDirs, catalog.Entries[0].Type, "the sidecar file splits it")
+		assert.Equal(t, "Wide", catalog.Entries[1].Title)
+		assert.Equal(t, pathTypeDirOfFiles, catalog.Entries[1].Type)
+		assert.Equal(t, 1, opens.opens["wide"], "the folder is only classified once")
+	})
+}
+
+// openCounter counts the files opened in FS
+type openCounter struct {
+	fs.FS
+	opens map[string]int
+}
+
+func (c openCounter) Open(name string) (fs.File, error) {
+	c.opens[name]++
+	return c.FS.Open(name)
+}
+
+// BenchmarkScanWideTree scans a root with 500 author folders, the case where
+// classifying every folder used to read all of them on every request
+func BenchmarkScanWideTree(b *testing.B) {
+	root := b.TempDir()
+	writeWideTree(b, root, 500, 10)
+
+	for _, bc := range []struct {
+		name string
+		s    OPDS
+	}{
+		{"paginated", OPDS{TrustedRoot: root, HideDotFiles: true}},
+		{"no pagination", OPDS{TrustedRoot: root, HideDotFiles: true, NoPagination: true}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := bc.s.Scan(currentDirectory, "/", 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGetPathType classifies a folder with a thousand books
+func BenchmarkGetPathType(b *testing.B) {
+	root := b.TempDir()
+	writeWideTree(b, root, 1, 1000)
+	fsys := LocalStorage{Root: root}
+
+	for b.Loop() {
+		if getPathType(fsys, "Author 000", nil) != pathTypeDirOfFiles {
+			b.Fatal("not a folder of books")
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const zipType = "application/zip"
+
+type zipFile struct {
+	path    string
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// ZipHandler streams the books of a folder as a ZIP archive built on the fly.
+// The folder is given in the dir query parameter; recursive=true also
+// includes the books of every subfolder.
+func (s OPDS) ZipHandler(w http.ResponseWriter, req *http.Request) error {
+	s.User = UserFromContext(req.Context())
+
+	dirPath := req.URL.Query().Get("dir")
+	if dirPath == "" {
+		return fmt.Errorf("missing dir parameter")
+	}
+
+	urlPath, err := url.PathUnescape(dirPath)
+	if err != nil {
+		slog.Error("error unescaping zip path", "dirPath", dirPath, "error", err)
+		return err
+	}
+
+	// storagePath avoid the http transversal by checking the path is under the root of the storage
+	name, ok := storagePath(urlPath)
+	if !ok {
+		slog.Error("verify path error for zip", "urlPath", urlPath)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	fsys := s.storage()
+	if info, err := fs.Stat(fsys, name); err != nil || !info.IsDir() || !s.allowed(urlPath, true) || s.ignored(name, true) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	recursive := req.URL.Query().Get("recursive") == "true"
+	files, total, err := s.zipFiles(name, recursive)
+	if err != nil {
+		slog.Error("error listing files for zip", "name", name, "error", err)
+		return err
+	}
+
+	if s.ZipMaxSize > 0 && total > s.ZipMaxSize {
+		http.Error(w, fmt.Sprintf("folder is too large to download (%s, limit %s)",
+			formatSize(total), formatSize(s.ZipMaxSize)), http.StatusRequestEntityTooLarge)
+		return nil
+	}
+
+	countDownload(req, zipName(urlPath))
+	w.Header().Set("Content-Type", zipType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": zipName(urlPath),
+	}))
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		if err := addZipFile(zw, fsys, f); err != nil {
+			// the headers are already sent, the only option left is to cut the stream.
+			slog.Error("error streaming zip", "path", f.path, "error", err)
+			return nil
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		slog.Error("error closing zip", "name", name, "error", err)
+	}
+	return nil
+}
+
+// zipFiles returns the files under dir that can be downloaded and their total size.
+func (s OPDS) zipFiles(dir string, recursive bool) ([]zipFile, int64, error) {
+	var files []zipFile
+	var total int64
+
+	fsys := s.storage()
+	walker := s.newIgnoreWalker(dir)
+	err := fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == dir {
+			return nil
+		}
+		if fileShouldBeIgnored(d.Name(), s.HideCalibreFiles, s.HideDotFiles) || walker.ignored(name, d) || !s.allowed("/"+name, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if !recursive {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		// the storage refuses the symlinks that point outside of the library
+		info, err := fs.Stat(fsys, name)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel := name
+		if dir != currentDirectory {
+			rel = strings.TrimPrefix(name, dir+"/")
+		}
+
+		files = append(files, zipFile{
+			path:    name,
+			name:    rel,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		total += info.Size()
+		return nil
+	})
+
+	return files, total, err
+}
+
+func addZipFile(zw *zip.Writer, fsys fs.FS, f zipFile) error {
+	src, err := fsys.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// books are already compressed, storing them avoids burning CPU for nothing.
+	dst, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     f.name,
+		Method:   zip.Store,
+		Modified: f.modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func zipName(urlPath string) string {
+	name := path.Base(path.Clean("/" + urlPath))
+	if name == "/" || name == "." {
+		name = "library"
+	}
+	return name + ".zip"
+}
+
+func zipURL(urlPath string, recursive bool) string {
+	query := url.Values{}
+	query.Set("dir", urlPath)
+	if recursive {
+		query.Set("recursive", strconv.FormatBool(recursive))
+	}
+	return "/zip?" + query.Encode()
+}
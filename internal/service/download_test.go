@@ -0,0 +1,112 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func zipEntryNames(t *testing.T, body []byte) []string {
+	t.Helper()
+	r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestZipHandler(t *testing.T) {
+	s := OPDS{
+		TrustedRoot:       "testdata",
+		HideCalibreFiles:  true,
+		HideDotFiles:      true,
+		EnableZipDownload: true,
+	}
+
+	t.Run("folder", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/zip?dir=/mybook", nil)
+
+		require.NoError(t, s.ZipHandler(rec, req))
+
+		resp := rec.Result()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+		assert.Equal(t, "attachment; filename=mybook.zip", resp.Header.Get("Content-Disposition"))
+		assert.Equal(t, []string{"mybook copy.epub", "mybook copy.txt", "mybook.epub", "mybook.pdf", "mybook.txt"}, zipEntryNames(t, body))
+	})
+
+	t.Run("recursive", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/zip?dir=/&recursive=true", nil)
+
+		require.NoError(t, s.ZipHandler(rec, req))
+
+		body, err := io.ReadAll(rec.Result().Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, `attachment; filename=library.zip`, rec.Header().Get("Content-Disposition"))
+		names := zipEntryNames(t, body)
+		assert.Contains(t, names, "mybook/mybook.epub")
+		assert.Contains(t, names, "new folder/mybook.txt")
+		assert.NotContains(t, names, "mybook/mybook.opf")
+	})
+
+	t.Run("not recursive skips subfolders", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/zip?dir=/", nil)
+
+		require.NoError(t, s.ZipHandler(rec, req))
+
+		body, err := io.ReadAll(rec.Result().Body)
+		require.NoError(t, err)
+		assert.Empty(t, zipEntryNames(t, body))
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		limited := s
+		limited.ZipMaxSize = 10
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/zip?dir=/mybook", nil)
+
+		require.NoError(t, limited.ZipHandler(rec, req))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+
+	t.Run("path traversal", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/zip?dir=/../../", nil)
+
+		require.NoError(t, s.ZipHandler(rec, req))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("file is not a folder", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/zip?dir=/mybook/mybook.txt", nil)
+
+		require.NoError(t, s.ZipHandler(rec, req))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestZipName(t *testing.T) {
+	assert.Equal(t, "library.zip", zipName("/"))
+	assert.Equal(t, "library.zip", zipName(""))
+	assert.Equal(t, "mybook.zip", zipName("/mybook"))
+	assert.Equal(t, "new folder.zip", zipName("/a/new folder/"))
+}
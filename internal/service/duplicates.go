@@ -0,0 +1,316 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The ways the copies of a DuplicateGroup are found
+const (
+	// DuplicateContent are files with the same bytes
+	DuplicateContent = "content"
+	// DuplicateMetadata are books of the same format with the same title and
+	// author, or in the same folder with the same name when they have no
+	// metadata
+	DuplicateMetadata = "metadata"
+)
+
+// copySuffix matches the marks file managers and browsers add to the name of
+// a copy, like "mybook copy", "mybook - Copy 2" or "mybook (1)"
+var copySuffix = regexp.MustCompile(`(?i)(\s*-?\s*copy(\s*\d+)?|\s*\(\d+\))$`)
+
+// DuplicateReport lists the books of a library stored more than once
+type DuplicateReport struct {
+	Groups []DuplicateGroup `json:"groups"`
+	// Wasted is the size of the copies that are not the best one
+	Wasted int64 `json:"wasted"`
+}
+
+// DuplicateGroup is a set of copies of the same book, the best one first
+type DuplicateGroup struct {
+	Reason string          `json:"reason"`
+	Books  []DuplicateBook `json:"books"`
+}
+
+// DuplicateBook is a copy of a book, Path is its URL path
+type DuplicateBook struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Title   string    `json:"title,omitempty"`
+	Author  string    `json:"author,omitempty"`
+	// Best is the copy kept when the duplicates are hidden
+	Best bool `json:"best"`
+}
+
+// Print writes the report for people, with a star on the best copies
+func (r DuplicateReport) Print(w io.Writer) error {
+	for _, group := range r.Groups {
+		fmt.Fprintf(w, "same %s:\n", group.Reason)
+		for _, book := range group.Books {
+			mark := " "
+			if book.Best {
+				mark = "*"
+			}
+			fmt.Fprintf(w, "  %s %s (%s)\n", mark, book.Path, formatSize(book.Size))
+		}
+		fmt.Fprintln(w)
+	}
+	groups := "groups"
+	if len(r.Groups) == 1 {
+		groups = "group"
+	}
+	_, err := fmt.Fprintf(w, "%d %s of duplicates, %s in copies with the same content\n", len(r.Groups), groups, formatSize(r.Wasted))
+	return err
+}
+
+// duplicates are the groups of copies of an index, and the copies to hide
+// with the better ones, all by their name in the storage
+type duplicates struct {
+	report DuplicateReport
+	hidden map[string][]string
+}
+
+// Duplicates walks the whole library and reports its duplicate books
+func (s OPDS) Duplicates() DuplicateReport {
+	return s.findDuplicates(s.indexBooks(context.Background())).report
+}
+
+// findDuplicates groups the books first by their content, and then by their
+// metadata. A group by metadata is only reported when it adds to the ones
+// by content, the different formats of a book are not duplicates.
+func (s OPDS) findDuplicates(books []CatalogEntry) duplicates {
+	var groups [][]CatalogEntry
+	var reasons []string
+
+	bySize := make(map[int64][]CatalogEntry)
+	for _, book := range books {
+		if book.Size > 0 {
+			bySize[book.Size] = append(bySize[book.Size], book)
+		}
+	}
+	// only the books with the size of another one are read
+	sameContent := make(map[string]string)
+	for _, size := range slices.Sorted(maps.Keys(bySize)) {
+		if len(bySize[size]) < 2 {
+			continue
+		}
+		byHash := make(map[string][]CatalogEntry)
+		for _, book := range bySize[size] {
+			hash, err := s.hashFile(book.Name)
+			if err != nil {
+				slog.Error("error hashing book", "name", book.Name, "error", err)
+				continue
+			}
+			byHash[hash] = append(byHash[hash], book)
+		}
+		for _, hash := range slices.Sorted(maps.Keys(byHash)) {
+			if copies := byHash[hash]; len(copies) > 1 {
+				for _, c := range copies {
+					sameContent[c.Name] = hash
+				}
+				groups = append(groups, copies)
+				reasons = append(reasons, DuplicateContent)
+			}
+		}
+	}
+
+	byMetadata := make(map[string][]CatalogEntry)
+	for _, book := range books {
+		key := metadataKey(book)
+		byMetadata[key] = append(byMetadata[key], book)
+	}
+	for _, key := range slices.Sorted(maps.Keys(byMetadata)) {
+		copies := byMetadata[key]
+		if len(copies) < 2 {
+			continue
+		}
+		hash := sameContent[copies[0].Name]
+		if hash != "" && !slices.ContainsFunc(copies, func(c CatalogEntry) bool { return sameContent[c.Name] != hash }) {
+			continue
+		}
+		groups = append(groups, copies)
+		reasons = append(reasons, DuplicateMetadata)
+	}
+
+	d := duplicates{hidden: make(map[string][]string)}
+	d.report.Groups = []DuplicateGroup{}
+	for i, copies := range groups {
+		slices.SortFunc(copies, compareCopies)
+		group := DuplicateGroup{Reason: reasons[i]}
+		for j, c := range copies {
+			if j > 0 {
+				for _, better := range copies[:j] {
+					d.hidden[c.Name] = append(d.hidden[c.Name], better.Name)
+				}
+				if reasons[i] == DuplicateContent {
+					d.report.Wasted += c.Size
+				}
+			}
+			group.Books = append(group.Books, DuplicateBook{
+				Path:    s.mountPath("/" + c.Name),
+				Size:    c.Size,
+				ModTime: c.ModTime,
+				Title:   c.Title,
+				Author:  c.Author,
+				Best:    j == 0,
+			})
+		}
+		d.report.Groups = append(d.report.Groups, group)
+	}
+	return d
+}
+
+// hashFile returns the SHA-256 of the content of the file name
+func (s OPDS) hashFile(name string) (string, error) {
+	f, err := s.storage().Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return string(h.Sum(nil)), nil
+}
+
+// metadataKey is the normalized title and author of a book and its format.
+// A book without a title uses its name without the marks of a copy, and as
+// names like "Vol 1" are shared by different books, a book without both a
+// title and an author only matches the ones of its folder.
+func metadataKey(book CatalogEntry) string {
+	ext := strings.ToLower(path.Ext(book.Name))
+	title := book.Title
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(book.Name), path.Ext(book.Name))
+	}
+	for copySuffix.MatchString(title) {
+		title = copySuffix.ReplaceAllString(title, "")
+	}
+
+	// "Herbert, Frank" is "Frank Herbert"
+	author := normalizeWords(book.Author)
+	slices.Sort(author)
+	key := strings.Join(normalizeWords(title), " ") + "\x00" + strings.Join(author, " ") + "\x00" + ext
+	if book.Title == "" || len(author) == 0 {
+		key += "\x00" + path.Dir(book.Name)
+	}
+	return key
+}
+
+// normalizeWords returns the words of s in lower case without accents or
+// punctuation
+func normalizeWords(s string) []string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, norm.NFD.String(s))
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// compareCopies orders the copies of a book from the best one: the one with
+// more metadata, then the one that is not named as a copy, the oldest and
+// the one with the shortest path.
+func compareCopies(a, b CatalogEntry) int {
+	return cmp.Or(
+		cmp.Compare(metadataFields(b), metadataFields(a)),
+		cmp.Compare(namedAsCopy(a.Name), namedAsCopy(b.Name)),
+		a.ModTime.Compare(b.ModTime),
+		cmp.Compare(len(a.Name), len(b.Name)),
+		strings.Compare(a.Name, b.Name),
+	)
+}
+
+func metadataFields(e CatalogEntry) int {
+	n := len(e.Subjects)
+	for _, field := range []string{e.Title, e.Author, e.CoverPath, e.Description, e.Series, e.Language} {
+		if field != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func namedAsCopy(name string) int {
+	if copySuffix.MatchString(strings.TrimSuffix(path.Base(name), path.Ext(name))) {
+		return 1
+	}
+	return 0
+}
+
+// hiddenDuplicates returns the copies that are not the best one with the
+// better ones, nil unless HideDuplicates is set and the index is ready
+func (s OPDS) hiddenDuplicates() map[string][]string {
+	if !s.HideDuplicates || s.Index == nil {
+		return nil
+	}
+	d, _ := s.Index.duplicates(s)
+	return d.hidden
+}
+
+// hiddenDuplicate reports whether the book name is a copy to hide, because
+// the user can see a better one. The index can be older than the library, so
+// the better copy must still be there.
+func (s OPDS) hiddenDuplicate(hidden map[string][]string, name string) bool {
+	return slices.ContainsFunc(hidden[name], func(better string) bool {
+		if !s.allowed("/"+better, false) {
+			return false
+		}
+		_, err := fs.Stat(s.storage(), better)
+		return err == nil
+	})
+}
+
+// isAdmin reports whether the user of the request can use the admin endpoints
+func (s OPDS) isAdmin() bool {
+	acl := s.ACL
+	if acl == nil {
+		acl = &ACL{}
+	}
+	return acl.matches(s.User, s.Admins)
+}
+
+// DuplicatesHandler serves the DuplicateReport of the library as JSON to the
+// admins, and a 503 until the index is ready. The other users get a 404.
+func (s OPDS) DuplicatesHandler(w http.ResponseWriter, req *http.Request) error {
+	s.User = UserFromContext(req.Context())
+	if !s.isAdmin() {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	var d duplicates
+	ok := false
+	if s.Index != nil {
+		d, ok = s.Index.duplicates(s)
+	}
+	if !ok {
+		return serveIndexNotReady(w)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(d.report)
+}
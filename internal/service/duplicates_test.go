@@ -0,0 +1,144 @@
+package service
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuplicates(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := old.AddDate(1, 0, 0)
+	dune := testEpubMetadata(t, `<dc:title>Dune</dc:title><dc:creator>Frank Herbert</dc:creator>`)
+
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"Dune.epub":                       {Data: dune, ModTime: recent},
+			"downloads/Dune (1).epub":         {Data: dune, ModTime: old},
+			"downloads/Dune.pdf":              {Data: []byte("%PDF dune"), ModTime: old},
+			"herbert/dune-first-edition.epub": {Data: testEpubMetadata(t, `<dc:title>Dúne</dc:title><dc:creator>Herbert, Frank</dc:creator><dc:subject>Science fiction</dc:subject>`), ModTime: recent},
+			"notes/Emma.txt":                  {Data: []byte("emma"), ModTime: old},
+			"notes/Emma copy.txt":             {Data: []byte("emma"), ModTime: old},
+			"notes/Persuasion.txt":            {Data: []byte("anne"), ModTime: old},
+			"private/Emma.txt":                {Data: []byte("emma"), ModTime: recent},
+			"Series A/Vol 1.cbz":              {Data: testZip(t, "1.jpg", "page of a"), ModTime: old},
+			"Series B/Vol 1.cbz":              {Data: testZip(t, "1.jpg", "page of b"), ModTime: old},
+		},
+		ExtractMetadata: true,
+		EnableSearch:    true,
+		PageSize:        10,
+	}
+
+	paths := func(group DuplicateGroup) []string {
+		var paths []string
+		for _, book := range group.Books {
+			paths = append(paths, book.Path)
+		}
+		return paths
+	}
+
+	t.Run("report", func(t *testing.T) {
+		report := s.Duplicates()
+		require.Len(t, report.Groups, 3)
+
+		assert.Equal(t, DuplicateContent, report.Groups[0].Reason)
+		assert.Equal(t, []string{"/notes/Emma.txt", "/private/Emma.txt", "/notes/Emma copy.txt"}, paths(report.Groups[0]), "the copies named as one last, then the newest")
+		assert.True(t, report.Groups[0].Books[0].Best)
+		assert.False(t, report.Groups[0].Books[1].Best)
+
+		assert.Equal(t, DuplicateContent, report.Groups[1].Reason)
+		assert.Equal(t, []string{"/Dune.epub", "/downloads/Dune (1).epub"}, paths(report.Groups[1]))
+
+		assert.Equal(t, DuplicateMetadata, report.Groups[2].Reason)
+		assert.Equal(t, []string{"/herbert/dune-first-edition.epub", "/Dune.epub", "/downloads/Dune (1).epub"}, paths(report.Groups[2]), "the copy with more metadata first")
+		assert.Equal(t, "Herbert, Frank", report.Groups[2].Books[0].Author)
+
+		assert.Equal(t, int64(2*len("emma")+len(dune)), report.Wasted)
+	})
+
+	t.Run("hidden", func(t *testing.T) {
+		s := s
+		s.HideDuplicates = true
+		s.Index = &Index{}
+		s.Index.build(s)
+		s.ACL = &ACL{Rules: []ACLRule{{Path: "/herbert", Allow: []string{"frank"}}}}
+
+		body := testGet(t, s.Handler, "/notes", "").Body.String()
+		assert.Contains(t, body, "Emma.txt")
+		assert.Contains(t, body, "Persuasion.txt")
+		assert.NotContains(t, body, "Emma copy.txt")
+
+		body = testGet(t, s.SearchHandler, "/search?q=e", "").Body.String()
+		assert.Contains(t, body, "notes/Emma.txt")
+		assert.NotContains(t, body, "Emma copy.txt")
+		assert.NotContains(t, body, "private/Emma.txt")
+		assert.Contains(t, body, "downloads/Dune.pdf", "the other formats are not duplicates")
+
+		// the best copy is hidden by the ACL, the next one is shown
+		assert.Contains(t, body, `href="/Dune.epub"`)
+		assert.NotContains(t, body, "Dune (1).epub")
+		assert.NotContains(t, body, "herbert")
+
+		body = testGet(t, s.SearchHandler, "/search?q=e", "frank").Body.String()
+		assert.Contains(t, body, "herbert/dune-first-edition.epub")
+		assert.NotContains(t, body, "Dune (1).epub")
+		assert.NotContains(t, body, `href="/Dune.epub"`)
+
+		// the index is older than the deletion of the best copy
+		storage := maps.Clone(s.Storage.(fstest.MapFS))
+		delete(storage, "Dune.epub")
+		s.Storage = storage
+		assert.Contains(t, testGet(t, s.Handler, "/downloads", "").Body.String(), "Dune (1).epub")
+
+		// untitled books with the same name in different folders are different books
+		assert.Contains(t, testGet(t, s.Handler, "/Series%20A", "").Body.String(), "Vol 1.cbz")
+		assert.Contains(t, testGet(t, s.Handler, "/Series%20B", "").Body.String(), "Vol 1.cbz")
+	})
+
+	t.Run("endpoint", func(t *testing.T) {
+		s := s
+		s.Admins = []string{"@librarians"}
+		s.ACL = &ACL{Groups: map[string][]string{"librarians": {"alice"}}}
+
+		// it is being built
+		s.Index = &Index{refreshing: true}
+		w := testGet(t, s.DuplicatesHandler, "/_duplicates", "alice")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		s.Index = &Index{}
+		s.Index.build(s)
+
+		assert.Equal(t, http.StatusNotFound, testGet(t, s.DuplicatesHandler, "/_duplicates", "bob").Code)
+		assert.Equal(t, http.StatusNotFound, testGet(t, s.DuplicatesHandler, "/_duplicates", "").Code)
+
+		w = testGet(t, s.DuplicatesHandler, "/_duplicates", "alice")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var report DuplicateReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Len(t, report.Groups, 3)
+	})
+}
+
+func TestMetadataKey(t *testing.T) {
+	tests := []struct {
+		a, b CatalogEntry
+		same bool
+	}{
+		{CatalogEntry{Name: "a/Dune.epub"}, CatalogEntry{Name: "a/dune - Copy 2.epub"}, true},
+		{CatalogEntry{Name: "Series A/Vol 1.cbz"}, CatalogEntry{Name: "Series B/Vol 1.cbz"}, false},
+		{CatalogEntry{Name: "a/x.epub", Title: "Emma"}, CatalogEntry{Name: "b/y.epub", Title: "Emma"}, false},
+		{CatalogEntry{Name: "Dune.epub"}, CatalogEntry{Name: "Dune.pdf"}, false},
+		{CatalogEntry{Name: "x.epub", Title: "Les Misérables", Author: "Victor Hugo"}, CatalogEntry{Name: "y.epub", Title: "les miserables", Author: "Hugo, Victor"}, true},
+		{CatalogEntry{Name: "x.epub", Title: "Emma", Author: "Jane Austen"}, CatalogEntry{Name: "y.epub", Title: "Emma", Author: "Someone Else"}, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.same, metadataKey(tt.a) == metadataKey(tt.b), "%s and %s", tt.a.Name, tt.b.Name)
+	}
+}
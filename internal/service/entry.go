@@ -0,0 +1,218 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+// entryType is the media type of the complete entry of a book
+const entryType = "application/atom+xml;type=entry;profile=opds-catalog"
+
+// entryURL returns the path of the complete entry of the book at urlPath
+func entryURL(urlPath string) string {
+	return "/_entry?file=" + url.QueryEscape(urlPath)
+}
+
+// EntryHandler serves the complete OPDS entry of a book, with all of its
+// metadata and formats, or its detail page in the HTML view
+func (s OPDS) EntryHandler(w http.ResponseWriter, req *http.Request) error {
+	s.User = UserFromContext(req.Context())
+
+	filePath := req.URL.Query().Get("file")
+	if filePath == "" {
+		return fmt.Errorf("missing file parameter")
+	}
+
+	// storagePath avoid the http transversal by checking the path is under the root of the storage
+	name, ok := storagePath(filePath)
+	if !ok || name == currentDirectory {
+		slog.Error("verify path error for entry", "urlPath", filePath)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	urlPath := "/" + name
+
+	bookPath := name
+	if archivePath, _, ok := s.splitArchivePath(name); ok {
+		bookPath = archivePath
+	}
+	if !s.allowed(urlPath, false) || s.ignored(bookPath, false) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	book, formats, err := s.book(name)
+	if err != nil {
+		slog.Error("error reading book", "name", name, "error", err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	related := s.related(name, book)
+
+	if s.EnableHTML && isBrowser(req) {
+		return s.renderBook(w, req, urlPath, book, formats, related)
+	}
+
+	entry := s.completeEntry(urlPath, book, formats, related)
+	content, err := xml.MarshalIndent(opds.EntryDocument{
+		Entry: &entry,
+		Dc:    "http://purl.org/dc/terms/",
+		Opds:  "http://opds-spec.org/2010/catalog",
+	}, "  ", "    ")
+	if err != nil {
+		slog.Error("error marshaling entry", "error", err)
+		return err
+	}
+
+	content = append([]byte(xml.Header), content...)
+	w.Header().Set("Content-Type", entryType)
+	http.ServeContent(w, req, "entry.xml", TimeNow(), bytes.NewReader(content))
+	return nil
+}
+
+// book returns the entry of the book name with its metadata, and its formats:
+// itself and the books of its folder with the same name and another extension,
+// like Dune.epub and Dune.pdf.
+func (s OPDS) book(name string) (CatalogEntry, []CatalogEntry, error) {
+	fsys := s.storage()
+	member := name
+	// the members of an archive are matched with their path in the storage
+	rulesDir := path.Dir(name)
+	if archivePath, m, ok := s.splitArchivePath(name); ok {
+		a, err := openArchive(fsys, archivePath)
+		if err != nil {
+			return CatalogEntry{}, nil, err
+		}
+		defer a.Close()
+		fsys, member, rulesDir = a, m, path.Dir(archivePath)
+	}
+
+	info, err := fs.Stat(fsys, member)
+	if err != nil {
+		return CatalogEntry{}, nil, err
+	}
+	if info.IsDir() {
+		return CatalogEntry{}, nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	book := CatalogEntry{
+		Name:    path.Base(name),
+		Type:    pathTypeFile,
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+	}
+	formats := []CatalogEntry{book}
+	if s.ExtractMetadata {
+		book.setMetadata(extractMetadata(fsys, member))
+	}
+
+	dirEntries, err := fs.ReadDir(fsys, path.Dir(member))
+	if err != nil {
+		slog.Error("error listing the formats of a book", "name", name, "error", err)
+		return book, formats, nil
+	}
+
+	dir := path.Dir(name)
+	stem := strings.TrimSuffix(book.Name, path.Ext(book.Name))
+	rules := s.ignoreRules(rulesDir)
+	for _, entry := range dirEntries {
+		other := entry.Name()
+		if entry.IsDir() || other == book.Name || strings.TrimSuffix(other, path.Ext(other)) != stem ||
+			fileShouldBeIgnored(other, s.HideCalibreFiles, s.HideDotFiles) || controlFile(other) ||
+			rules.match(path.Join(dir, other), false) || !s.allowed("/"+path.Join(dir, other), false) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			slog.Error("error getting info for entry", "error", err)
+			continue
+		}
+		formats = append(formats, CatalogEntry{
+			Name:    other,
+			Type:    pathTypeFile,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		})
+	}
+	return book, formats, nil
+}
+
+// folderType returns the type of the feed of the folder name, as Scan
+// presents it: the folders of archives, the sidecar files and the split mixed
+// folders change it
+func (s OPDS) folderType(name string) int {
+	if archivePath, member, ok := s.splitArchivePath(name); ok {
+		a, err := openArchive(s.storage(), archivePath)
+		if err != nil {
+			slog.Error("error opening archive", "path", archivePath, "error", err)
+			return pathTypeDirOfFiles
+		}
+		defer a.Close()
+		return a.pathType(member)
+	}
+	folder := s.readFolderConfig(name)
+	return folder.pathType(s.withFolder(folder).pathType(name, s.parentIgnoreRules(name)))
+}
+
+// completeEntry builds the complete entry of the book at urlPath. It has the
+// same id as the partial one of its folder, the whole description as content,
+// a link to download each format and links to the related books.
+func (s OPDS) completeEntry(urlPath string, book CatalogEntry, formats []CatalogEntry, related []relatedGroup) opds.Entry {
+	dir := path.Dir(urlPath)
+	entry := s.makeEntry(book, s.mountPath(dir)+book.Name, urlPath, s.signedURL((&url.URL{Path: urlPath}).String()))
+	entry.Link[0].Length = uint(book.Size)
+
+	for _, format := range formats[1:] {
+		entry.Link = append(entry.Link, opds.LinkBuilder.
+			Rel(getRel(format.Name, pathTypeFile)).
+			Title(format.Name).
+			Href(s.signedURL((&url.URL{Path: path.Join(dir, format.Name)}).String())).
+			Type(s.getType(format.Name, pathTypeFile)).
+			Length(uint(format.Size)).
+			Build())
+	}
+
+	entry.Link = append(entry.Link,
+		opds.LinkBuilder.
+			Rel("self").
+			Href(s.joinURL(entryURL(urlPath))).
+			Type(entryType).
+			Build(),
+		opds.LinkBuilder.
+			Rel("related").
+			Href(s.joinURL((&url.URL{Path: dir}).String())).
+			Type(s.getType(dir, s.folderType(path.Dir(strings.TrimPrefix(urlPath, "/"))))).
+			Title("Catalog in "+s.mountPath(dir)).
+			Build(),
+	)
+	for _, group := range related {
+		for _, other := range group.Books {
+			entry.Link = append(entry.Link, opds.LinkBuilder.
+				Rel("related").
+				Href(s.joinURL(entryURL("/"+other.Name))).
+				Type(entryType).
+				Title(cmp.Or(other.Title, path.Base(other.Name))).
+				Build())
+		}
+	}
+
+	if entry.Summary != nil {
+		entry.Content, entry.Summary = entry.Summary, nil
+	}
+	if s.ExtractMetadata {
+		entry.DcLanguage = book.Language
+	}
+	return entry
+}
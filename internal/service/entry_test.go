@@ -0,0 +1,84 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntryHandler(t *testing.T) {
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"shelf/.opdsignore": {Data: []byte("*.txt\n")},
+			"shelf/Dune.epub":   {Data: testEpub(t, "Herbert, Frank", "en-US", "Science fiction")},
+			"shelf/Dune.pdf":    {Data: []byte("%PDF")},
+			"shelf/Dune.txt":    {Data: []byte("ignored")},
+			"shelf/Emma.epub":   {Data: []byte("emma")},
+		},
+		ExtractMetadata: true,
+	}
+	t.Run("complete entry", func(t *testing.T) {
+		w := testGet(t, s.EntryHandler, "/_entry?file=%2Fshelf%2FDune.epub", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, entryType, w.Header().Get("Content-Type"))
+
+		body := w.Body.String()
+		assert.Contains(t, body, `<entry xmlns="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/terms/" xmlns:opds="http://opds-spec.org/2010/catalog">`)
+		assert.Contains(t, body, `<id>/shelfDune.epub</id>`, "the id of the partial entry")
+		assert.Contains(t, body, `<link rel="http://opds-spec.org/acquisition/open-access" href="/shelf/Dune.epub" type="application/epub+zip" title="Dune.epub" length="308"></link>`)
+		assert.Contains(t, body, `<link rel="http://opds-spec.org/acquisition/open-access" href="/shelf/Dune.pdf" type="application/pdf" title="Dune.pdf" length="4"></link>`)
+		assert.Contains(t, body, `<link rel="self" href="/_entry?file=%2Fshelf%2FDune.epub" type="application/atom+xml;type=entry;profile=opds-catalog"></link>`)
+		assert.Contains(t, body, `<link rel="related" href="/shelf" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="Catalog in /shelf"></link>`)
+		assert.Contains(t, body, `<name>Herbert, Frank</name>`)
+		assert.Contains(t, body, `<dc:language>en-US</dc:language>`)
+		assert.Contains(t, body, `<category term="Science fiction" label="Science fiction"></category>`)
+		assert.NotContains(t, body, "Dune.txt", "ignored formats are left out")
+		assert.NotContains(t, body, "Emma.epub")
+	})
+
+	t.Run("partial entries link to it", func(t *testing.T) {
+		assert.Contains(t, testGet(t, s.Handler, "/shelf", "").Body.String(), `<link rel="alternate" href="/_entry?file=%2Fshelf%2FEmma.epub" type="application/atom+xml;type=entry;profile=opds-catalog"></link>`)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		for _, target := range []string{
+			"/_entry?file=%2Fshelf%2FMissing.epub",
+			"/_entry?file=%2Fshelf",
+			"/_entry?file=%2Fshelf%2FDune.txt",
+			"/_entry?file=%2F..%2Fetc%2Fpasswd",
+		} {
+			assert.Equal(t, http.StatusNotFound, testGet(t, s.EntryHandler, target, "").Code, target)
+		}
+	})
+
+	t.Run("html", func(t *testing.T) {
+		s := s
+		s.EnableHTML = true
+		body := testGet(t, s.EntryHandler, "/_entry?file=%2Fshelf%2FDune.epub", "", "Accept", "text/html").Body.String()
+		assert.Contains(t, body, `<h2>Dune.epub</h2>`)
+		assert.Contains(t, body, `By Herbert, Frank`)
+		assert.Contains(t, body, `Language: English`)
+		assert.Contains(t, body, `<a href="/shelf/Dune.epub">&#x2B73; EPUB (308 B)</a>`)
+		assert.Contains(t, body, `<a href="/shelf/Dune.pdf">&#x2B73; PDF (4 B)</a>`)
+		assert.Contains(t, body, `<a href="/_entry?file=%2Fshelf%2FDune.epub">Dune.epub</a>`, "the book ends the breadcrumbs")
+	})
+
+	t.Run("kind of the folder", func(t *testing.T) {
+		s := OPDS{
+			Storage: fstest.MapFS{
+				"mixed/Dune.epub":        {Data: []byte("dune")},
+				"mixed/series/Part.epub": {Data: []byte("part")},
+				"box.zip":                {Data: testZip(t, "novels/Emma.epub", "emma")},
+			},
+			MixedFolders:   MixedSplit,
+			BrowseArchives: true,
+		}
+		body := testGet(t, s.EntryHandler, "/_entry?file=%2Fmixed%2FDune.epub", "").Body.String()
+		assert.Contains(t, body, `<link rel="related" href="/mixed" type="application/atom+xml;profile=opds-catalog;kind=navigation" title="Catalog in /mixed"></link>`, "a split mixed folder")
+
+		body = testGet(t, s.EntryHandler, "/_entry?file=%2Fbox.zip%2Fnovels%2FEmma.epub", "").Body.String()
+		assert.Contains(t, body, `<link rel="related" href="/box.zip/novels" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="Catalog in /box.zip/novels"></link>`, "a folder of an archive")
+	})
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ExportReport counts the files written by Export
+type ExportReport struct {
+	Pages int   `json:"pages"`
+	Books int   `json:"books"`
+	Size  int64 `json:"size"`
+	// Errors are the pages and books that could not be written, they are logged
+	Errors int `json:"errors"`
+}
+
+// Print writes the report for people
+func (r ExportReport) Print(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%d pages and %d books written, %s, %d errors\n", r.Pages, r.Books, formatSize(r.Size), r.Errors)
+	return err
+}
+
+// staticSite maps the links of the feeds and the HTML view to the files of a
+// static export, and keeps the ones still to be written
+type staticSite struct {
+	// root is the path of the base URL, where the links of the HTML view start
+	root string
+	// written has the files of the export, with the name of the book they are
+	// a copy of or "" for the pages
+	written map[string]string
+	pending []staticFile
+	covers  map[string]string
+	// clashes are the files that a book and a page both need
+	clashes []string
+}
+
+// staticFile is a file of the export, target is its path in it. It is the
+// response to url, to a browser when html, or the book name of the storage.
+type staticFile struct {
+	target string
+	url    string
+	html   bool
+	book   string
+}
+
+// Export renders the library to static files in out that any web server can
+// host: the feeds with all of their pages, the HTML view when EnableHTML, the
+// complete entries, the covers and the OpenSearch document, next to a copy of
+// the books. They are rendered by the handlers of the server, only the links
+// change to go to the files, named index.xml and index.html in each folder.
+//
+// What needs a running server is left out: the sort and filter facets, the
+// ZIP downloads and the signed links. The links to the search, and the
+// template of the OpenSearch document, go to /search under the BaseURL. A
+// book with the name of a page, like index.xml, is an error.
+func (s OPDS) Export(out string) (ExportReport, error) {
+	var report ExportReport
+	site := &staticSite{written: make(map[string]string), covers: make(map[string]string)}
+	if s.BaseURL != "" {
+		u, err := url.Parse(s.BaseURL)
+		if err != nil {
+			return report, err
+		}
+		site.root = u.Path
+	}
+
+	// the export is what everybody can see
+	s.static = site
+	s.Prefix = ""
+	s.User = ""
+	s.Signer = nil
+	s.EnableAuth = false
+	s.EnableCache = false
+	s.NoCache = false
+	s.EnableZipDownload = false
+	s.BrowseArchives = false
+	if s.RelatedBooks || s.HideDuplicates {
+		s.Index = &Index{}
+		s.Index.build(s)
+	}
+
+	site.link(s, "/", false)
+	if s.EnableHTML {
+		site.link(s, "/", true)
+	}
+	if s.EnableSearch {
+		site.link(s, "/opensearch.xml", false)
+	}
+
+	for len(site.pending) > 0 {
+		file := site.pending[0]
+		site.pending = site.pending[1:]
+
+		name := filepath.Join(out, filepath.FromSlash(file.target))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return report, err
+		}
+
+		if file.book != "" {
+			n, err := s.exportBook(name, file.book)
+			if err != nil {
+				if n < 0 {
+					return report, err
+				}
+				slog.Error("error exporting book", "name", file.book, "error", err)
+				report.Errors++
+				continue
+			}
+			report.Books++
+			report.Size += n
+			continue
+		}
+
+		content, err := s.exportPage(file)
+		if err != nil {
+			slog.Error("error exporting page", "url", file.url, "error", err)
+			report.Errors++
+			continue
+		}
+		if err := os.WriteFile(name, content, 0o644); err != nil {
+			return report, err
+		}
+		report.Pages++
+		report.Size += int64(len(content))
+	}
+
+	for _, target := range site.clashes {
+		slog.Error("error exporting", "file", target, "error", "a book has the name of a page")
+		report.Errors++
+	}
+	return report, nil
+}
+
+// exportPage renders file with the handler of its url
+func (s OPDS) exportPage(file staticFile) ([]byte, error) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, file.url, nil)
+	if file.html {
+		req.Header.Set("Accept", "text/html")
+	}
+
+	var err error
+	switch req.URL.Path {
+	case "/opensearch.xml":
+		s.OpenSearchHandler(w, req)
+	case "/_entry":
+		err = s.EntryHandler(w, req)
+	case "/cover":
+		err = s.CoverHandler(w, req)
+	default:
+		err = s.Handler(w, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if w.Code != http.StatusOK {
+		return nil, fmt.Errorf("%d %s", w.Code, http.StatusText(w.Code))
+	}
+	return w.Body.Bytes(), nil
+}
+
+// exportBook copies the book name of the storage to the file target and
+// returns its size, or -1 when the error is writing the copy
+func (s OPDS) exportBook(target, name string) (int64, error) {
+	f, err := openFile(s.storage(), name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	dst, err := os.Create(target)
+	if err != nil {
+		return -1, err
+	}
+	n, err := io.Copy(dst, f)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return -1, err
+	}
+	// the copies keep the date of the books for the tools that sync them
+	modTime := f.info.ModTime()
+	if err := os.Chtimes(target, modTime, modTime); err != nil {
+		return -1, err
+	}
+	return n, nil
+}
+
+// link returns the link to the file of the export that p goes to, p being a
+// link of the feeds or, when html, of the HTML view, and adds the file to the
+// ones to write. The links to what needs a running server, like the search
+// and the ZIP downloads, are left as they are.
+func (site *staticSite) link(s OPDS, p string, html bool) string {
+	rawPath, rawQuery, _ := strings.Cut(p, "?")
+	urlPath, err := url.PathUnescape(rawPath)
+	if err != nil {
+		urlPath = rawPath
+	}
+	query, _ := url.ParseQuery(rawQuery)
+	// the first page is the folder itself
+	if query.Get("page") == "1" {
+		query.Del("page")
+	}
+
+	ext := ".xml"
+	if html {
+		ext = ".html"
+	}
+
+	file := staticFile{html: html}
+	switch urlPath {
+	case "/search", "/zip":
+		return p
+	case "/opensearch.xml":
+		file.target = urlPath
+		file.html = false
+	case "/_entry":
+		file.target = path.Join("/_entries", query.Get("file")) + ext
+	case "/cover":
+		file.target = path.Join("/_covers", query.Get("file")) + site.coverExt(s, query.Get("file"))
+		file.html = false
+	default:
+		name, ok := storagePath(urlPath)
+		if !ok {
+			return p
+		}
+		info, err := fs.Stat(s.storage(), name)
+		if err != nil {
+			return p
+		}
+		if !info.IsDir() {
+			// the books keep their path
+			if site.add(urlPath, name) {
+				site.pending = append(site.pending, staticFile{target: urlPath, book: name})
+			}
+			return p
+		}
+
+		file.target = path.Join(urlPath, "index")
+		for _, key := range slices.Sorted(maps.Keys(query)) {
+			file.target += "-" + key + "-" + url.PathEscape(query.Get(key))
+		}
+		file.target += ext
+	}
+
+	if site.add(file.target, "") {
+		file.url = (&url.URL{Path: urlPath, RawQuery: query.Encode()}).String()
+		site.pending = append(site.pending, file)
+	}
+	return (&url.URL{Path: file.target}).String()
+}
+
+// add reports whether target is a new file of the export, a copy of the book
+// name or a page when "". The first one to need a target gets it, a book and
+// a page that need the same one clash.
+func (site *staticSite) add(target, name string) bool {
+	prev, ok := site.written[target]
+	if !ok {
+		site.written[target] = name
+		return true
+	}
+	if prev != name && !slices.Contains(site.clashes, target) {
+		site.clashes = append(site.clashes, target)
+	}
+	return false
+}
+
+// coverExt returns the extension of the cover of the book at urlPath, the
+// one of its image in the EPUB
+func (site *staticSite) coverExt(s OPDS, urlPath string) string {
+	if ext, ok := site.covers[urlPath]; ok {
+		return ext
+	}
+
+	ext := ".jpg"
+	if name, ok := storagePath(urlPath); ok {
+		_, _, coverPath, _, _, _, _, _ := extractMetadata(s.storage(), name)
+		if coverPath != "" {
+			ext = strings.ToLower(path.Ext(coverPath))
+		}
+	}
+	site.covers[urlPath] = ext
+	return ext
+}
+
+// startURL is the link to the start of the catalog, the list of the libraries
+// when there are several
+func (s OPDS) startURL() string {
+	if s.static != nil {
+		return s.joinURL("/")
+	}
+	return joinBaseURL(s.BaseURL, "/")
+}
+
+// pageURL returns the link of the HTML view to p, a path of the library
+func (s OPDS) pageURL(p string) string {
+	if s.static != nil {
+		return joinBaseURL(s.static.root, s.static.link(s, p, true))
+	}
+	return s.mountPath(p)
+}
+
+// homeURL is the link of the HTML view to the start of the catalog
+func (s OPDS) homeURL() string {
+	if s.static != nil {
+		return s.pageURL("/")
+	}
+	return "/"
+}
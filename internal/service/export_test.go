@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	emma := testZip(t,
+		"content.opf", `<package xmlns="http://www.idpf.org/2007/opf"><metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Emma</dc:title></metadata></package>`,
+		"images/cover.png", "png",
+	)
+
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"Dune/1 Dune.epub":           {Data: testEpubMetadata(t, `<dc:title>Dune</dc:title>`), ModTime: modTime},
+			"Dune/2 Dune Messiah.epub":   {Data: testEpubMetadata(t, `<dc:title>Dune Messiah</dc:title>`)},
+			"Dune/3 Children.epub":       {Data: testEpubMetadata(t, `<dc:title>Children of Dune</dc:title>`)},
+			"Dune/cover.jpg":             {Data: []byte("jpg")},
+			"Austen/Emma.epub":           {Data: emma},
+			"Austen/.opdsignore":         {Data: []byte("*.txt\n")},
+			"Austen/Emma.txt":            {Data: []byte("ignored")},
+			"Austen/Persuasion.pdf":      {Data: []byte("%PDF")},
+			"Austen/Pride and Prejudice": {Data: []byte("unknown")},
+		},
+		ExtractMetadata:   true,
+		ShowCovers:        true,
+		EnableHTML:        true,
+		EnableSearch:      true,
+		EnableZipDownload: true,
+		PageSize:          2,
+		BaseURL:           "https://example.com/books",
+		Prefix:            "/fiction",
+	}
+
+	out := t.TempDir()
+	report, err := s.Export(out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Errors)
+	assert.Equal(t, 7, report.Books, "the books and the cover of the folder")
+
+	read := func(name string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	for _, name := range []string{
+		"index.xml", "index.html", "opensearch.xml",
+		"Dune/index.xml", "Dune/index-page-2.xml", "Dune/index-complete-true.xml",
+		"Dune/index.html", "Dune/index-page-2.html",
+		"_entries/Dune/1 Dune.epub.xml", "_entries/Dune/1 Dune.epub.html",
+	} {
+		assert.FileExists(t, filepath.Join(out, filepath.FromSlash(name)))
+	}
+	assert.NoFileExists(t, filepath.Join(out, "Austen", "Emma.txt"))
+	assert.Contains(t, read("opensearch.xml"), `template="https://example.com/books/search?q={searchTerms}"`)
+
+	assert.Equal(t, "png", read("_covers/Austen/Emma.epub.png"))
+	assert.Equal(t, string(s.Storage.(fstest.MapFS)["Dune/1 Dune.epub"].Data), read("Dune/1 Dune.epub"))
+	info, err := os.Stat(filepath.Join(out, "Dune", "1 Dune.epub"))
+	require.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(modTime), "the copies keep the date of the books")
+
+	feed := read("Dune/index.xml")
+	assert.Contains(t, feed, `href="https://example.com/books/index.xml"`)
+	assert.Contains(t, feed, `href="https://example.com/books/Dune/index-page-2.xml"`)
+	assert.Contains(t, feed, `href="https://example.com/books/Dune/index-complete-true.xml"`)
+	assert.Contains(t, feed, `href="https://example.com/books/Dune/1%20Dune.epub"`)
+	assert.Contains(t, feed, `href="https://example.com/books/Dune/cover.jpg"`)
+	assert.Contains(t, feed, `href="https://example.com/books/_entries/Dune/1%20Dune.epub.xml"`)
+	assert.Contains(t, feed, `href="https://example.com/books/opensearch.xml"`)
+	assert.NotContains(t, feed, "?page=")
+	assert.NotContains(t, feed, "/zip?", "the ZIP downloads need a server")
+	assert.NotContains(t, feed, "http://opds-spec.org/facet")
+	assert.Contains(t, read("Dune/index-page-2.xml"), `rel="previous" href="https://example.com/books/Dune/index.xml"`)
+
+	page := read("Dune/index.html")
+	assert.Contains(t, page, `href="/books/index.html">Home</a>`)
+	assert.Contains(t, page, `href="/books/Dune/index-page-2.html"`)
+	assert.Contains(t, page, `href="/books/_entries/Dune/1%20Dune.epub.html"`)
+	assert.NotContains(t, page, "sort=")
+
+	book := read("_entries/Austen/Emma.epub.html")
+	assert.Contains(t, book, `src="/books/_covers/Austen/Emma.epub.png"`)
+	assert.Contains(t, book, `href="/books/Austen/Emma.epub"`)
+	assert.Contains(t, book, `href="/books/Austen/index.html">Austen</a>`)
+
+	var printed bytes.Buffer
+	require.NoError(t, report.Print(&printed))
+	assert.Contains(t, printed.String(), "pages and 7 books written")
+
+	t.Run("a book named as a page", func(t *testing.T) {
+		s := OPDS{Storage: fstest.MapFS{
+			"Dune/index.xml": {Data: []byte("<book/>")},
+			"Dune/Dune.epub": {Data: []byte("epub")},
+		}}
+		out := t.TempDir()
+		report, err := s.Export(out)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Errors)
+		assert.Equal(t, 1, report.Books)
+	})
+}
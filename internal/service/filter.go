@@ -0,0 +1,207 @@
+package service
+
+import (
+	"cmp"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxFacetValues is the number of values of a filter group offered as facets,
+// the ones with the most books
+const maxFacetValues = 20
+
+// filterGroup is a facet group that narrows the books of a catalog to the ones
+// with a value, given in the query parameter param.
+type filterGroup struct {
+	param string
+	title string
+	// values returns the values of the book in the group, none when it has none
+	values func(e CatalogEntry) []string
+	label  func(value string) string
+}
+
+var filterGroups = []filterGroup{
+	{"format", "Format", formatValues, strings.ToUpper},
+	{"lang", "Language", languageValues, languageName},
+	{"initial", "Author", initialValues, func(v string) string { return v }},
+	{"subject", "Subject", func(e CatalogEntry) []string { return e.Subjects }, func(v string) string { return v }},
+}
+
+// Facet is a value of a filter group with the number of books that have it,
+// an empty Value is the facet that removes the filter.
+type Facet struct {
+	Group  string
+	Param  string
+	Value  string
+	Label  string
+	Count  int
+	Active bool
+}
+
+func formatValues(e CatalogEntry) []string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(e.Name), "."))
+	if ext == "" {
+		return nil
+	}
+	return []string{ext}
+}
+
+// languageValues returns the base language of the book, so en-US and en-GB
+// are both en
+func languageValues(e CatalogEntry) []string {
+	tag, err := language.Parse(e.Language)
+	if err != nil {
+		return nil
+	}
+	base, confidence := tag.Base()
+	if confidence == language.No {
+		return nil
+	}
+	return []string{base.String()}
+}
+
+// languageName is the name of the language in itself, like français for fr
+func languageName(code string) string {
+	tag := language.Make(code)
+	if name := display.Self.Name(tag); name != "" {
+		return name
+	}
+	return code
+}
+
+// initialValues returns the first letter of the author without accents, # when
+// it is not a letter
+func initialValues(e CatalogEntry) []string {
+	author := strings.TrimSpace(e.Author)
+	if author == "" {
+		return nil
+	}
+	r := []rune(norm.NFD.String(author))[0]
+	if !unicode.IsLetter(r) {
+		return []string{"#"}
+	}
+	return []string{string(unicode.ToUpper(r))}
+}
+
+// url returns the path of req with the query that picks the facet, back on the
+// first page as the number of entries changes
+func (f Facet) url(req *http.Request) string {
+	query := cloneURLValues(req.URL.Query())
+	query.Del("page")
+	if f.Value == "" {
+		query.Del(f.Param)
+	} else {
+		query.Set(f.Param, f.Value)
+	}
+	if len(query) == 0 {
+		return req.URL.Path
+	}
+	return req.URL.Path + "?" + query.Encode()
+}
+
+// getFilterFromQuery returns the filters of the request, keyed by parameter
+func getFilterFromQuery(req *http.Request) map[string]string {
+	var filter map[string]string
+	for _, g := range filterGroups {
+		if value := req.URL.Query().Get(g.param); value != "" {
+			if filter == nil {
+				filter = map[string]string{}
+			}
+			filter[g.param] = value
+		}
+	}
+	return filter
+}
+
+// matches reports whether the book has the values of the filters of every
+// group but skip
+func (s OPDS) matches(e CatalogEntry, skip string) bool {
+	for _, g := range filterGroups {
+		value, ok := s.Filter[g.param]
+		if !ok || g.param == skip {
+			continue
+		}
+		if !slices.Contains(g.values(e), value) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the facets of the filter groups and the entries that match
+// s.Filter. The counts of a group are the books that match the filters of the
+// other groups, so they tell how many books a tap leaves. Folders are never
+// filtered out.
+func (s OPDS) filter(entries []CatalogEntry) ([]Facet, []CatalogEntry) {
+	var facets []Facet
+	for _, g := range filterGroups {
+		counts := map[string]int{}
+		total := 0
+		for _, e := range entries {
+			if e.Type != pathTypeFile || !s.matches(e, g.param) {
+				continue
+			}
+			total++
+			for _, value := range g.values(e) {
+				counts[value]++
+			}
+		}
+
+		active := s.Filter[g.param]
+		// a group with a single value doesn't narrow anything
+		if len(counts) < 2 && active == "" {
+			continue
+		}
+		if _, ok := counts[active]; active != "" && !ok {
+			counts[active] = 0
+		}
+
+		values := make([]string, 0, len(counts))
+		for value := range counts {
+			values = append(values, value)
+		}
+		slices.SortFunc(values, func(a, b string) int {
+			return cmp.Or(cmp.Compare(counts[b], counts[a]), strings.Compare(a, b))
+		})
+		if len(values) > maxFacetValues {
+			top := values[:maxFacetValues]
+			if active != "" && !slices.Contains(top, active) {
+				top = append(top[:maxFacetValues-1], active)
+			}
+			values = top
+		}
+		slices.SortFunc(values, func(a, b string) int {
+			return strings.Compare(g.label(a), g.label(b))
+		})
+
+		facets = append(facets, Facet{Group: g.title, Param: g.param, Label: "All", Count: total, Active: active == ""})
+		for _, value := range values {
+			facets = append(facets, Facet{
+				Group:  g.title,
+				Param:  g.param,
+				Value:  value,
+				Label:  g.label(value),
+				Count:  counts[value],
+				Active: value == active,
+			})
+		}
+	}
+
+	if len(s.Filter) == 0 {
+		return facets, entries
+	}
+	filtered := entries[:0:0]
+	for _, e := range entries {
+		if e.Type != pathTypeFile || s.matches(e, "") {
+			filtered = append(filtered, e)
+		}
+	}
+	return facets, filtered
+}
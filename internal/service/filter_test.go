@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterFacets(t *testing.T) {
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"shelf/Dune.epub":       {Data: testEpub(t, "Herbert, Frank", "en-US", "Science fiction")},
+			"shelf/Emma.epub":       {Data: testEpub(t, "Austen, Jane", "en", "Romance")},
+			"shelf/Germinal.epub":   {Data: testEpub(t, "Zola, Émile", "fr", "Classics")},
+			"shelf/Nana.pdf":        {Data: []byte("%PDF")},
+			"shelf/Persuasion.epub": {Data: testEpub(t, "Austen, Jane", "en-GB", "Romance")},
+			"shelf/more/a.epub":     {Data: []byte("epub")},
+		},
+		ExtractMetadata: true,
+	}
+
+	facet := func(href, title, group string, count int, active bool) string {
+		link := fmt.Sprintf(`<link rel="http://opds-spec.org/facet" href="%s" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="%s" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="%s"`, href, title, group)
+		if active {
+			link += ` catalog:activeFacet="true"`
+		}
+		return link + fmt.Sprintf(` thr:count="%d"></link>`, count)
+	}
+
+	t.Run("groups", func(t *testing.T) {
+		body := testGet(t, s.Handler, "/shelf", "").Body.String()
+		assert.Contains(t, body, `xmlns:thr="http://purl.org/syndication/thread/1.0"`)
+		assert.Contains(t, body, facet("/shelf", "All", "Format", 5, true))
+		assert.Contains(t, body, facet("/shelf?format=epub", "EPUB", "Format", 4, false))
+		assert.Contains(t, body, facet("/shelf?format=pdf", "PDF", "Format", 1, false))
+		assert.Contains(t, body, facet("/shelf?lang=en", "English", "Language", 3, false))
+		assert.Contains(t, body, facet("/shelf?lang=fr", "français", "Language", 1, false))
+		assert.Contains(t, body, facet("/shelf?initial=A", "A", "Author", 2, false))
+		assert.Contains(t, body, facet("/shelf?initial=Z", "Z", "Author", 1, false), "without the accent")
+		assert.Contains(t, body, facet("/shelf?subject=Romance", "Romance", "Subject", 2, false))
+	})
+
+	t.Run("two taps", func(t *testing.T) {
+		body := testGet(t, s.Handler, "/shelf?format=epub&lang=en&page=1", "").Body.String()
+		assert.Contains(t, body, "<title>Dune.epub</title>")
+		assert.Contains(t, body, "<title>Emma.epub</title>")
+		assert.Contains(t, body, "<title>Persuasion.epub</title>")
+		assert.NotContains(t, body, "<title>Germinal.epub</title>")
+		assert.NotContains(t, body, "<title>Nana.pdf</title>")
+		assert.Contains(t, body, "<title>more</title>", "folders are not filtered")
+
+		// the counts of a group follow the filters of the others, the page is dropped
+		assert.Contains(t, body, facet("/shelf?format=epub&amp;lang=en", "EPUB", "Format", 3, true))
+		assert.Contains(t, body, facet("/shelf?lang=en", "All", "Format", 3, false))
+		assert.Contains(t, body, facet("/shelf?format=epub&amp;lang=fr", "français", "Language", 1, false))
+		assert.Contains(t, body, facet("/shelf?format=epub&amp;lang=en&amp;subject=Romance", "Romance", "Subject", 2, false))
+	})
+
+	t.Run("unknown value", func(t *testing.T) {
+		body := testGet(t, s.Handler, "/shelf?format=mobi", "").Body.String()
+		assert.NotContains(t, body, ".epub</title>")
+		assert.Contains(t, body, facet("/shelf?format=mobi", "MOBI", "Format", 0, true), "the count of no matches is shown")
+	})
+
+	t.Run("html", func(t *testing.T) {
+		s := s
+		s.EnableHTML = true
+		body := testGet(t, s.Handler, "/shelf?format=pdf", "", "Accept", "text/html").Body.String()
+		assert.Contains(t, body, `<span class="current">PDF (1)</span>`)
+		assert.Contains(t, body, `<a href="/shelf?format=epub">EPUB (4)</a>`)
+	})
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// folderConfigName is the sidecar file that customizes the catalog of its folder
+const folderConfigName = ".dir2opds.yaml"
+
+// folderConfig overrides how a folder is presented, in its own feed and in the
+// entry of its parent feed.
+type folderConfig struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	// Cover is an image of the folder, relative to it
+	Cover string `yaml:"cover"`
+	Sort  string `yaml:"sort"`
+	// Order is asc or desc
+	Order string `yaml:"order"`
+	// Kind is navigation or acquisition
+	Kind string `yaml:"kind"`
+	// Mixed is how the folder is presented when it has books and subfolders
+	Mixed string `yaml:"mixed"`
+}
+
+// pathType returns the type the folder is presented as, or pathType when Kind is not set
+func (c folderConfig) pathType(pathType int) int {
+	switch c.Kind {
+	case "navigation":
+		return pathTypeDirOfDirs
+	case "acquisition":
+		return pathTypeDirOfFiles
+	}
+	return pathType
+}
+
+// withFolder returns s with the defaults of the folder, the query of a
+// request still wins over them
+func (s OPDS) withFolder(c folderConfig) OPDS {
+	if c.Sort != "" {
+		s.SortBy = c.Sort
+	}
+	if c.Order != "" {
+		s.SortOrder = c.Order
+	}
+	if c.Mixed != "" {
+		s.MixedFolders = c.Mixed
+	}
+	return s
+}
+
+// readFolderConfigFrom is readFolderConfig for the folder dir already listed
+// in entries, the sidecar file is only read when it is one of them
+func (s OPDS) readFolderConfigFrom(dir string, entries []fs.DirEntry) folderConfig {
+	for _, entry := range entries {
+		if entry.Name() == folderConfigName {
+			return s.readFolderConfig(dir)
+		}
+	}
+	return folderConfig{}
+}
+
+// readFolderConfig reads the sidecar file of dir. A missing or invalid file
+// leaves the folder as it is, the errors are only logged.
+func (s OPDS) readFolderConfig(dir string) folderConfig {
+	if _, _, ok := s.splitArchivePath(dir); ok {
+		return folderConfig{}
+	}
+
+	data, err := fs.ReadFile(s.storage(), path.Join(dir, folderConfigName))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("error reading folder config", "dir", dir, "error", err)
+		}
+		return folderConfig{}
+	}
+
+	var c folderConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		slog.Error("invalid folder config", "dir", dir, "error", err)
+		return folderConfig{}
+	}
+
+	if c.Sort != "" && !ValidSort(c.Sort) {
+		slog.Error("invalid folder config", "dir", dir, "error", "unknown sort "+c.Sort)
+		c.Sort = ""
+	}
+	if c.Order != "" && !ValidSortOrder(c.Order) {
+		slog.Error("invalid folder config", "dir", dir, "error", "order must be asc or desc")
+		c.Order = ""
+	}
+	if c.Kind != "" && c.Kind != "navigation" && c.Kind != "acquisition" {
+		slog.Error("invalid folder config", "dir", dir, "error", "kind must be navigation or acquisition")
+		c.Kind = ""
+	}
+	if c.Mixed != "" && !ValidMixedFolders(c.Mixed) {
+		slog.Error("invalid folder config", "dir", dir, "error", "mixed must be acquisition or split")
+		c.Mixed = ""
+	}
+	if c.Cover != "" {
+		cover := path.Clean(c.Cover)
+		if path.IsAbs(cover) || cover == currentDirectory || cover == parentDirectory || strings.HasPrefix(cover, parentDirectory+"/") {
+			slog.Error("invalid folder config", "dir", dir, "error", "the cover must be inside the folder")
+			cover = ""
+		}
+		c.Cover = cover
+	}
+	return c
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFolderConfig(t *testing.T) {
+	modTime := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"club/.dir2opds.yaml": {Data: []byte("title: Book club 2026\n" +
+				"description: The books we read this year.\n" +
+				"cover: art/banner.jpg\n" +
+				"sort: size\n")},
+			"club/art/banner.jpg":    {Data: []byte("jpeg")},
+			"club/Dune.epub":         {Data: []byte("a short one"), ModTime: modTime},
+			"club/Middlemarch.epub":  {Data: []byte("a much longer book"), ModTime: modTime},
+			"series/.dir2opds.yaml":  {Data: []byte("kind: acquisition\ncover: shelf.png\n")},
+			"series/shelf.png":       {Data: []byte("png")},
+			"series/one/a.epub":      {Data: []byte("a")},
+			"broken/.dir2opds.yaml":  {Data: []byte("title: [not a title\n")},
+			"broken/book.epub":       {Data: []byte("b")},
+			"unknown/.dir2opds.yaml": {Data: []byte("title: Unknown\nsort: colour\ncolour: red\n")},
+			"escape/.dir2opds.yaml":  {Data: []byte("title: Escape\ncover: ../club/art/banner.jpg\n")},
+			"escape/book.epub":       {Data: []byte("e")},
+		},
+		HideDotFiles: true,
+	}
+
+	t.Run("read", func(t *testing.T) {
+		assert.Equal(t, folderConfig{Kind: "acquisition", Cover: "shelf.png"}, s.readFolderConfig("series"))
+		assert.Equal(t, folderConfig{}, s.readFolderConfig("broken"), "invalid yaml")
+		assert.Equal(t, folderConfig{}, s.readFolderConfig("unknown"), "unknown field")
+		assert.Equal(t, folderConfig{Title: "Escape"}, s.readFolderConfig("escape"), "cover outside of the folder")
+		assert.Equal(t, folderConfig{}, s.readFolderConfig("missing"))
+	})
+
+	t.Run("read from the listing", func(t *testing.T) {
+		entries, err := fs.ReadDir(s.Storage, "series")
+		require.NoError(t, err)
+		assert.Equal(t, folderConfig{Kind: "acquisition", Cover: "shelf.png"}, s.readFolderConfigFrom("series", entries))
+		// the sidecar file is not looked for when the listing doesn't have it
+		assert.Equal(t, folderConfig{}, s.readFolderConfigFrom("series", entries[1:]))
+	})
+
+	t.Run("feed of the folder", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/club/", nil)))
+		body := w.Body.String()
+		assert.Contains(t, body, "<title>Book club 2026</title>")
+		assert.Contains(t, body, "<subtitle>The books we read this year.</subtitle>")
+		assert.Contains(t, body, `<link rel="http://opds-spec.org/image" href="/club/art/banner.jpg" type="image/jpeg"></link>`)
+		assert.NotContains(t, body, ".dir2opds.yaml")
+		assert.Contains(t, body, `<link rel="http://opds-spec.org/facet" href="/club/?sort=size" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="Size" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Sort By" catalog:activeFacet="true">`)
+
+		// the folder sorts by size, the biggest first
+		assert.Less(t, strings.Index(body, "<title>Middlemarch.epub</title>"), strings.Index(body, "<title>Dune.epub</title>"))
+	})
+
+	t.Run("the request sort wins", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/club/?sort=name", nil)))
+		body := w.Body.String()
+		assert.Less(t, strings.Index(body, "<title>Dune.epub</title>"), strings.Index(body, "<title>Middlemarch.epub</title>"))
+	})
+
+	t.Run("entry in the parent feed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/", nil)))
+		body := w.Body.String()
+		assert.Contains(t, body, "<title>Book club 2026</title>")
+		assert.Contains(t, body, "<summary>The books we read this year.</summary>")
+		assert.Contains(t, body, `<link rel="http://opds-spec.org/image/thumbnail" href="/club/art/banner.jpg" type="image/jpeg"></link>`)
+		assert.Contains(t, body, `<link rel="subsection" href="/series" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="series"></link>`)
+		assert.Contains(t, body, `href="/series/shelf.png"`)
+	})
+
+	t.Run("kind", func(t *testing.T) {
+		catalog, err := s.Scan("series", "/series", 1)
+		require.NoError(t, err)
+		assert.Equal(t, pathTypeDirOfFiles, catalog.Type)
+		assert.Equal(t, "/series/shelf.png", catalog.Cover)
+		require.Len(t, catalog.Entries, 1)
+		assert.Equal(t, "one", catalog.Entries[0].Name)
+	})
+
+	t.Run("sidecar is not served", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/club/.dir2opds.yaml", nil)))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
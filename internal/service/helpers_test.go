@@ -0,0 +1,77 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testEpub returns an EPUB with just the metadata of its package document
+func testEpub(t *testing.T, author, language string, subjects ...string) []byte {
+	t.Helper()
+
+	var metadata strings.Builder
+	fmt.Fprintf(&metadata, `<dc:creator>%s</dc:creator><dc:language>%s</dc:language>`, author, language)
+	for _, subject := range subjects {
+		fmt.Fprintf(&metadata, `<dc:subject>%s</dc:subject>`, subject)
+	}
+	return testEpubMetadata(t, metadata.String())
+}
+
+// testEpubMetadata returns an EPUB whose package document has the given metadata elements
+func testEpubMetadata(t *testing.T, metadata string) []byte {
+	t.Helper()
+
+	opf := `<package xmlns="http://www.idpf.org/2007/opf"><metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` +
+		metadata + `</metadata></package>`
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("OEBPS/content.opf")
+	require.NoError(t, err)
+	_, err = w.Write([]byte(opf))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// testBook returns an EPUB with a title, an author and, when series is not empty, its place in the series
+func testBook(t *testing.T, title, author, series, index string, subjects ...string) []byte {
+	t.Helper()
+
+	var metadata strings.Builder
+	fmt.Fprintf(&metadata, `<dc:title>%s</dc:title><dc:creator>%s</dc:creator>`, title, author)
+	if series != "" {
+		fmt.Fprintf(&metadata, `<meta name="calibre:series" content="%s"/>`, series)
+	}
+	if index != "" {
+		fmt.Fprintf(&metadata, `<meta name="calibre:series_index" content="%s"/>`, index)
+	}
+	for _, subject := range subjects {
+		fmt.Fprintf(&metadata, `<dc:subject>%s</dc:subject>`, subject)
+	}
+	return testEpubMetadata(t, metadata.String())
+}
+
+// testGet runs handler on a GET of target, sent by user when it is not empty and with the header pairs given
+func testGet(t *testing.T, handler func(http.ResponseWriter, *http.Request) error, target, user string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if user != "" {
+		req = req.WithContext(context.WithValue(req.Context(), userKey{}, user))
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	require.NoError(t, handler(w, req))
+	return w
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"cmp"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -62,6 +63,10 @@ const htmlTemplate = `
             margin: 0 5px;
             color: #999;
         }
+        .description {
+            margin-bottom: 20px;
+            color: #555;
+        }
         .search-box {
             margin-bottom: 30px;
             text-align: center;
@@ -133,6 +138,84 @@ const htmlTemplate = `
             font-size: 0.85rem;
             color: #777;
         }
+        .download {
+            margin-bottom: 20px;
+            text-align: right;
+        }
+        .download a {
+            padding: 8px 15px;
+            background-color: var(--accent-color);
+            color: white;
+            text-decoration: none;
+            border-radius: 4px;
+        }
+        .book {
+            background-color: var(--card-bg);
+            padding: 20px;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.05);
+            display: flex;
+            align-items: flex-start;
+        }
+        .book-cover {
+            width: 160px;
+            margin-right: 25px;
+            border-radius: 4px;
+            box-shadow: 0 1px 3px rgba(0,0,0,0.2);
+        }
+        .book h2 {
+            margin-top: 0;
+            color: var(--primary-color);
+        }
+        .formats {
+            margin-top: 20px;
+            display: flex;
+            flex-wrap: wrap;
+            gap: 10px;
+        }
+        .formats a {
+            padding: 8px 15px;
+            background-color: var(--accent-color);
+            color: white;
+            text-decoration: none;
+            border-radius: 4px;
+        }
+        .sort {
+            margin-bottom: 20px;
+            font-size: 0.9rem;
+            color: #666;
+        }
+        .sort a {
+            margin-left: 8px;
+            color: var(--accent-color);
+            text-decoration: none;
+        }
+        .sort span.separator {
+            margin-left: 8px;
+        }
+        .sort span.current {
+            margin-left: 8px;
+            font-weight: bold;
+        }
+        .stats {
+            background-color: var(--card-bg);
+            padding: 20px;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.05);
+        }
+        .stats table {
+            width: 100%;
+            border-collapse: collapse;
+            margin-bottom: 20px;
+        }
+        .stats th, .stats td {
+            text-align: left;
+            padding: 4px 8px;
+            border-bottom: 1px solid #eee;
+        }
+        .stats td.number {
+            text-align: right;
+        }
         .pagination {
             display: flex;
             justify-content: center;
@@ -172,7 +255,7 @@ const htmlTemplate = `
     <div class="container">
         {{if .EnableSearch}}
         <div class="search-box">
-            <form action="/search" method="get">
+            <form action="{{.SearchURL}}" method="get">
                 <input type="text" name="q" placeholder="Search books..." value="{{.Query}}">
                 <button type="submit">Search</button>
             </form>
@@ -180,13 +263,135 @@ const htmlTemplate = `
         {{end}}
 
         <div class="breadcrumb">
-            <a href="/">Home</a>
+            <a href="{{.HomeURL}}">Home</a>
             {{range .Breadcrumbs}}
                 <span>/</span>
                 <a href="{{.Path}}">{{.Name}}</a>
             {{end}}
         </div>
 
+        {{if .Catalog.Description}}
+        <p class="description">{{.Catalog.Description}}</p>
+        {{end}}
+
+        {{if .ZipURL}}
+        <div class="download">
+            <a href="{{.ZipURL}}">&#x2B73; Download folder (ZIP)</a>
+        </div>
+        {{end}}
+
+        {{if .SortLinks}}
+        <div class="sort">
+            Sort by:
+            {{range .SortLinks}}
+                {{if .Active}}<span class="current">{{.Label}}</span>{{else}}<a href="{{.URL}}">{{.Label}}</a>{{end}}
+            {{end}}
+            <span class="separator">|</span>
+            {{range .OrderLinks}}
+                {{if .Active}}<span class="current">{{.Label}}</span>{{else}}<a href="{{.URL}}">{{.Label}}</a>{{end}}
+            {{end}}
+        </div>
+        {{end}}
+
+        {{range .FilterGroups}}
+        <div class="sort">
+            {{.Title}}:
+            {{range .Links}}
+                {{if .Active}}<span class="current">{{.Label}} ({{.Count}})</span>{{else}}<a href="{{.URL}}">{{.Label}} ({{.Count}})</a>{{end}}
+            {{end}}
+        </div>
+        {{end}}
+
+        {{with .Book}}
+        <div class="book">
+            {{if .CoverURL}}
+            <img src="{{.CoverURL}}" class="book-cover" alt="Cover">
+            {{end}}
+            <div class="entry-details">
+                <h2>{{if .Title}}{{.Title}}{{else}}{{.Name}}{{end}}</h2>
+                <div class="entry-meta">
+                    {{if .Author}}By {{.Author}}<br>{{end}}
+                    {{if .Series}}Series: {{.Series}}{{if .SeriesIndex}} #{{.SeriesIndex}}{{end}}<br>{{end}}
+                    {{if .LanguageName}}Language: {{.LanguageName}}<br>{{end}}
+                    {{if .Subjects}}Subjects:{{range $i, $subject := .Subjects}}{{if $i}},{{end}} {{$subject}}{{end}}<br>{{end}}
+                    Modified: {{.ModTimeDisplay}}
+                </div>
+                {{if .Description}}
+                <p class="description">{{.Description}}</p>
+                {{end}}
+                <div class="formats">
+                    {{range .Formats}}
+                    <a href="{{.URL}}">&#x2B73; {{.Label}} ({{.SizeDisplay}})</a>
+                    {{end}}
+                </div>
+            </div>
+        </div>
+        {{if .Related}}
+        <h3>More like this</h3>
+        {{range .Related}}
+        <div class="sort">{{.Title}}</div>
+        <ul class="entry-list">
+            {{range .Books}}
+            <li class="entry-item">
+                {{if .CoverURL}}
+                <img src="{{.CoverURL}}" class="entry-cover" alt="Cover">
+                {{else}}
+                <div class="entry-icon">📄</div>
+                {{end}}
+                <div class="entry-details">
+                    <div class="entry-title">
+                        <a href="{{.Href}}">{{.Title}}</a>
+                    </div>
+                    <div class="entry-meta">
+                        {{if .Author}}By {{.Author}}{{end}}
+                        {{if .Series}}{{if .Author}} | {{end}}{{.Series}}{{if .SeriesIndex}} #{{.SeriesIndex}}{{end}}{{end}}
+                    </div>
+                </div>
+            </li>
+            {{end}}
+        </ul>
+        {{end}}
+        {{end}}
+        {{else with .Stats}}
+        <div class="stats">
+            <h2>{{.Books}} books, {{.SizeDisplay}}</h2>
+            <h3>Missing metadata</h3>
+            <table>
+                <tr><td>Without title</td><td class="number">{{.Missing.Title}}</td></tr>
+                <tr><td>Without author</td><td class="number">{{.Missing.Author}}</td></tr>
+                <tr><td>Without cover</td><td class="number">{{.Missing.Cover}}</td></tr>
+            </table>
+            <h3>Formats</h3>
+            <table>
+                <tr><th>Format</th><th>Books</th><th>Size</th></tr>
+                {{range .Formats}}<tr><td>{{.Name}}</td><td class="number">{{.Books}}</td><td class="number">{{.SizeDisplay}}</td></tr>{{end}}
+            </table>
+            {{if .Authors}}
+            <h3>Authors</h3>
+            <table>
+                <tr><th>Author</th><th>Books</th></tr>
+                {{range .Authors}}<tr><td>{{.Name}}</td><td class="number">{{.Books}}</td></tr>{{end}}
+            </table>
+            {{if .MoreAuthors}}<p>And {{.MoreAuthors}} more.</p>{{end}}
+            {{end}}
+            {{if .Series}}
+            <h3>Series</h3>
+            <table>
+                <tr><th>Series</th><th>Books</th></tr>
+                {{range .Series}}<tr><td>{{.Name}}</td><td class="number">{{.Books}}</td></tr>{{end}}
+            </table>
+            {{if .MoreSeries}}<p>And {{.MoreSeries}} more.</p>{{end}}
+            {{end}}
+            <h3>Largest books</h3>
+            <table>
+                {{range .Largest}}<tr><td><a href="{{.Href}}">{{.Title}}</a></td><td class="number">{{.SizeDisplay}}</td></tr>{{end}}
+            </table>
+            <h3>Recently added</h3>
+            <table>
+                {{range .Recent}}<tr><td><a href="{{.Href}}">{{.Title}}</a></td><td class="number">{{.ModTimeDisplay}}</td></tr>{{end}}
+            </table>
+        </div>
+        {{else}}
         <ul class="entry-list">
             {{range .Entries}}
             <li class="entry-item">
@@ -212,6 +417,7 @@ const htmlTemplate = `
             <p>No entries found.</p>
             {{end}}
         </ul>
+        {{end}}
 
         {{if gt .TotalPages 1}}
         <div class="pagination">
@@ -238,6 +444,24 @@ type Breadcrumb struct {
 	Path string
 }
 
+type SortLink struct {
+	Label  string
+	URL    string
+	Active bool
+}
+
+type FilterLink struct {
+	Label  string
+	URL    string
+	Count  int
+	Active bool
+}
+
+type FilterGroup struct {
+	Title string
+	Links []FilterLink
+}
+
 type HTMLEntry struct {
 	CatalogEntry
 	Href           string
@@ -246,16 +470,66 @@ type HTMLEntry struct {
 	ModTimeDisplay string
 }
 
+// HTMLFormat is a download of a book in one of its formats
+type HTMLFormat struct {
+	Label       string
+	URL         string
+	SizeDisplay string
+}
+
+// HTMLRelated is a list of books related to the one of a detail page
+type HTMLRelated struct {
+	Title string
+	Books []HTMLEntry
+}
+
+// HTMLBook is the detail page of a book
+type HTMLBook struct {
+	HTMLEntry
+	LanguageName string
+	Formats      []HTMLFormat
+	Related      []HTMLRelated
+}
+
+// HTMLStatsRow is a format, author or series of the statistics page
+type HTMLStatsRow struct {
+	Name        string
+	Books       int
+	SizeDisplay string
+}
+
+// HTMLStats is the statistics page of a library
+type HTMLStats struct {
+	Books       int
+	SizeDisplay string
+	Missing     StatsMissing
+	Formats     []HTMLStatsRow
+	Authors     []HTMLStatsRow
+	Series      []HTMLStatsRow
+	MoreAuthors int
+	MoreSeries  int
+	Largest     []HTMLEntry
+	Recent      []HTMLEntry
+}
+
 type HTMLData struct {
 	Catalog      *Catalog
 	Entries      []HTMLEntry
+	Book         *HTMLBook
+	Stats        *HTMLStats
 	Breadcrumbs  []Breadcrumb
+	SortLinks    []SortLink
+	OrderLinks   []SortLink
+	FilterGroups []FilterGroup
 	EnableSearch bool
 	Query        string
 	CurrentPage  int
 	TotalPages   int
 	PrevPageURL  string
 	NextPageURL  string
+	ZipURL       string
+	HomeURL      string
+	SearchURL    string
 }
 
 func (s OPDS) renderHTML(w http.ResponseWriter, req *http.Request, catalog *Catalog) error {
@@ -270,20 +544,49 @@ func (s OPDS) renderHTML(w http.ResponseWriter, req *http.Request, catalog *Cata
 		Query:        req.URL.Query().Get("q"),
 		CurrentPage:  catalog.Page,
 		TotalPages:   (catalog.Total + catalog.PageSize - 1) / catalog.PageSize,
+		HomeURL:      s.homeURL(),
+		SearchURL:    s.pageURL("/search"),
 	}
 
-	// Breadcrumbs
-	urlPath := strings.Trim(req.URL.Path, "/")
-	if urlPath != "" {
-		parts := strings.Split(urlPath, "/")
-		current := ""
-		for _, part := range parts {
-			current += "/" + part
-			data.Breadcrumbs = append(data.Breadcrumbs, Breadcrumb{
-				Name: part,
-				Path: current,
-			})
+	if s.EnableZipDownload && !strings.HasPrefix(catalog.ID, "search:") {
+		data.ZipURL = s.mountPath(zipURL(req.URL.Path, catalog.Type == pathTypeDirOfDirs))
+	}
+
+	data.Breadcrumbs = s.breadcrumbs(req.URL.Path)
+
+	// Sort links, the same as the facets of the feed
+	if catalog.Total > 1 && s.static == nil {
+		links := func(param, active string, options []facetOption) []SortLink {
+			var links []SortLink
+			for _, opt := range options {
+				query := cloneURLValues(req.URL.Query())
+				query.Set(param, opt.value)
+				query.Del("page")
+				links = append(links, SortLink{
+					Label:  opt.label,
+					URL:    s.mountPath(req.URL.Path) + "?" + query.Encode(),
+					Active: opt.value == active,
+				})
+			}
+			return links
 		}
+		data.SortLinks = links("sort", cmp.Or(s.SortBy, "name"), s.sortFacets())
+		data.OrderLinks = links("order", s.sortOrder(), orderOptions)
+	}
+
+	// Filter links, grouped like the facets of the feed
+	for _, facet := range catalog.Facets {
+		link := FilterLink{
+			Label:  facet.Label,
+			URL:    s.mountPath(facet.url(req)),
+			Count:  facet.Count,
+			Active: facet.Active,
+		}
+		if n := len(data.FilterGroups); n == 0 || data.FilterGroups[n-1].Title != facet.Group {
+			data.FilterGroups = append(data.FilterGroups, FilterGroup{Title: facet.Group})
+		}
+		group := &data.FilterGroups[len(data.FilterGroups)-1]
+		group.Links = append(group.Links, link)
 	}
 
 	// Entries
@@ -295,11 +598,20 @@ func (s OPDS) renderHTML(w http.ResponseWriter, req *http.Request, catalog *Cata
 			entryPath = path.Join(req.URL.Path, entry.Name)
 		}
 
-		href := (&url.URL{Path: entryPath}).String()
-		
+		href := s.pageURL((&url.URL{Path: entryPath}).String())
+		if entry.Query != "" {
+			href = s.pageURL((&url.URL{Path: req.URL.Path, RawQuery: entry.Query}).String())
+		}
+		// the books link to their detail page, with the downloads
+		if entry.Type == pathTypeFile {
+			href = s.pageURL(entryURL(entryPath))
+		}
+
 		var coverURL string
 		if s.ExtractMetadata && entry.CoverPath != "" && entry.Type == pathTypeFile {
-			coverURL = "/cover?file=" + url.QueryEscape(entryPath)
+			coverURL = s.pageURL("/cover?file=" + url.QueryEscape(entryPath))
+		} else if entry.CoverPath != "" && entry.Type != pathTypeFile {
+			coverURL = s.pageURL((&url.URL{Path: path.Join(entryPath, entry.CoverPath)}).String())
 		}
 
 		data.Entries = append(data.Entries, HTMLEntry{
@@ -313,10 +625,152 @@ func (s OPDS) renderHTML(w http.ResponseWriter, req *http.Request, catalog *Cata
 
 	// Pagination
 	if data.CurrentPage > 1 {
-		data.PrevPageURL = buildPageURL(req.URL.Path, req.URL.Query(), data.CurrentPage-1)
+		data.PrevPageURL = s.pageURL(buildPageURL(req.URL.Path, req.URL.Query(), data.CurrentPage-1))
 	}
 	if data.CurrentPage < data.TotalPages {
-		data.NextPageURL = buildPageURL(req.URL.Path, req.URL.Query(), data.CurrentPage+1)
+		data.NextPageURL = s.pageURL(buildPageURL(req.URL.Path, req.URL.Query(), data.CurrentPage+1))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return tmpl.Execute(w, data)
+}
+
+// breadcrumbs returns the links to the folders of urlPath, from the library down
+func (s OPDS) breadcrumbs(urlPath string) []Breadcrumb {
+	var breadcrumbs []Breadcrumb
+	if prefix := strings.TrimSuffix(s.Prefix, "/"); prefix != "" {
+		breadcrumbs = append(breadcrumbs, Breadcrumb{
+			Name: path.Base(prefix),
+			Path: s.pageURL("/"),
+		})
+	}
+	urlPath = strings.Trim(urlPath, "/")
+	if urlPath != "" {
+		var current string
+		parts := strings.Split(urlPath, "/")
+		for _, part := range parts {
+			current += "/" + part
+			breadcrumbs = append(breadcrumbs, Breadcrumb{
+				Name: part,
+				Path: s.pageURL(current),
+			})
+		}
+	}
+	return breadcrumbs
+}
+
+// renderBook renders the detail page of the book at urlPath
+func (s OPDS) renderBook(w http.ResponseWriter, req *http.Request, urlPath string, book CatalogEntry, formats []CatalogEntry, related []relatedGroup) error {
+	tmpl, err := template.New("catalog").Parse(htmlTemplate)
+	if err != nil {
+		return err
+	}
+
+	data := HTMLData{
+		Catalog:      &Catalog{Title: cmp.Or(book.Title, book.Name)},
+		Breadcrumbs:  s.breadcrumbs(urlPath),
+		EnableSearch: s.EnableSearch,
+		HomeURL:      s.homeURL(),
+		SearchURL:    s.pageURL("/search"),
+	}
+	// the last breadcrumb is the book itself
+	data.Breadcrumbs[len(data.Breadcrumbs)-1].Path = s.pageURL(entryURL(urlPath))
+
+	html := &HTMLBook{
+		HTMLEntry: HTMLEntry{
+			CatalogEntry:   book,
+			ModTimeDisplay: book.ModTime.Format("2006-01-02"),
+		},
+	}
+	if !s.ExtractMetadata {
+		html.CatalogEntry = CatalogEntry{Name: book.Name, ModTime: book.ModTime, Size: book.Size}
+	}
+	if s.ExtractMetadata && book.CoverPath != "" {
+		html.CoverURL = s.pageURL("/cover?file=" + url.QueryEscape(urlPath))
+	}
+	if langs := languageValues(book); s.ExtractMetadata && len(langs) > 0 {
+		html.LanguageName = languageName(langs[0])
+	}
+	for _, format := range formats {
+		label := strings.ToUpper(strings.TrimPrefix(path.Ext(format.Name), "."))
+		html.Formats = append(html.Formats, HTMLFormat{
+			Label:       cmp.Or(label, format.Name),
+			URL:         s.pageURL((&url.URL{Path: path.Join(path.Dir(urlPath), format.Name)}).String()),
+			SizeDisplay: formatSize(format.Size),
+		})
+	}
+	for _, group := range related {
+		r := HTMLRelated{Title: group.Title}
+		for _, other := range group.Books {
+			otherPath := "/" + other.Name
+			entry := HTMLEntry{
+				CatalogEntry: other,
+				Href:         s.pageURL(entryURL(otherPath)),
+			}
+			entry.Title = cmp.Or(other.Title, path.Base(other.Name))
+			if other.CoverPath != "" {
+				entry.CoverURL = s.pageURL("/cover?file=" + url.QueryEscape(otherPath))
+			}
+			r.Books = append(r.Books, entry)
+		}
+		html.Related = append(html.Related, r)
+	}
+	data.Book = html
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return tmpl.Execute(w, data)
+}
+
+// statsRows is the number of authors and series of the statistics page
+const statsRows = 25
+
+// renderStats renders the statistics page of the library
+func (s OPDS) renderStats(w http.ResponseWriter, stats Stats) error {
+	tmpl, err := template.New("catalog").Parse(htmlTemplate)
+	if err != nil {
+		return err
+	}
+
+	rows := func(counts []StatsCount) ([]HTMLStatsRow, int) {
+		var rows []HTMLStatsRow
+		for _, c := range counts[:min(len(counts), statsRows)] {
+			rows = append(rows, HTMLStatsRow{Name: c.Name, Books: c.Books, SizeDisplay: formatSize(c.Size)})
+		}
+		return rows, len(counts) - len(rows)
+	}
+	books := func(list []StatsBook) []HTMLEntry {
+		var entries []HTMLEntry
+		for _, book := range list {
+			// the paths of the stats have the prefix of the library
+			urlPath := strings.TrimPrefix(book.Path, strings.TrimSuffix(s.Prefix, "/"))
+			entries = append(entries, HTMLEntry{
+				CatalogEntry:   CatalogEntry{Name: book.Path, Title: cmp.Or(book.Title, path.Base(book.Path)), Author: book.Author},
+				Href:           s.mountPath(entryURL(urlPath)),
+				SizeDisplay:    formatSize(book.Size),
+				ModTimeDisplay: book.ModTime.Format("2006-01-02"),
+			})
+		}
+		return entries
+	}
+
+	html := &HTMLStats{
+		Books:       stats.Books,
+		SizeDisplay: formatSize(stats.Size),
+		Missing:     stats.Missing,
+		Largest:     books(stats.Largest),
+		Recent:      books(stats.Recent),
+	}
+	html.Formats, _ = rows(stats.Formats)
+	html.Authors, html.MoreAuthors = rows(stats.Authors)
+	html.Series, html.MoreSeries = rows(stats.Series)
+
+	data := HTMLData{
+		Catalog:      &Catalog{Title: "Statistics"},
+		Breadcrumbs:  s.breadcrumbs("/_stats"),
+		EnableSearch: s.EnableSearch,
+		HomeURL:      "/",
+		SearchURL:    s.mountPath("/search"),
+		Stats:        html,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
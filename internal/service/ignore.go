@@ -0,0 +1,256 @@
+package service
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ignoreFileName is the file with the gitignore-style patterns of the files to
+// hide in its folder and the folders under it.
+const ignoreFileName = ".opdsignore"
+
+// controlFile reports whether name is one of the files that configure a folder,
+// they are never listed nor served.
+func controlFile(name string) bool {
+	return name == ignoreFileName || name == folderConfigName
+}
+
+// ignorePattern is a line of an .opdsignore file, or of the global list
+type ignorePattern struct {
+	// base is the folder of the .opdsignore file, the patterns are relative to it
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// ignoreRules are the patterns that apply to a folder, when several match a
+// path the last one wins, so deeper .opdsignore files override the upper ones.
+type ignoreRules []ignorePattern
+
+// ValidIgnorePattern reports whether pattern is a valid gitignore-style pattern
+func ValidIgnorePattern(pattern string) bool {
+	p, ok := parseIgnorePattern(currentDirectory, pattern)
+	return !ok || p.valid()
+}
+
+// parseIgnorePattern parses a line, ok is false for blank lines and comments
+func parseIgnorePattern(base, line string) (ignorePattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	p := ignorePattern{base: base}
+	if negated, ok := strings.CutPrefix(line, "!"); ok {
+		p.negate = true
+		line = negated
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if dir, ok := strings.CutSuffix(line, "/"); ok {
+		p.dirOnly = true
+		line = dir
+	}
+
+	// a slash at the beginning or in the middle anchors the pattern to its base,
+	// without it the pattern matches at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	p.segments = strings.Split(line, "/")
+	if !anchored {
+		p.segments = append([]string{"**"}, p.segments...)
+	}
+	return p, true
+}
+
+func parseIgnore(base string, lines []string) ignoreRules {
+	var rules ignoreRules
+	for _, line := range lines {
+		p, ok := parseIgnorePattern(base, line)
+		if !ok {
+			continue
+		}
+		if !p.valid() {
+			slog.Warn("invalid ignore pattern", "folder", base, "pattern", line)
+			continue
+		}
+		rules = append(rules, p)
+	}
+	return rules
+}
+
+// matchSegments matches the segments of a path with the ones of a pattern,
+// ** matches any number of segments.
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		// a trailing ** matches everything inside, not the folder itself
+		if len(pattern) == 1 {
+			return len(name) > 0
+		}
+		for i := range len(name) + 1 {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], name[0])
+	return ok && matchSegments(pattern[1:], name[1:])
+}
+
+func (p ignorePattern) valid() bool {
+	for _, segment := range p.segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (p ignorePattern) matches(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	rel := name
+	if p.base != currentDirectory {
+		var ok bool
+		if rel, ok = strings.CutPrefix(name, p.base+"/"); !ok {
+			return false
+		}
+	}
+	return matchSegments(p.segments, strings.Split(rel, "/"))
+}
+
+// match reports whether the name of the storage is ignored
+func (r ignoreRules) match(name string, isDir bool) bool {
+	ignored := false
+	for _, p := range r {
+		if p.matches(name, isDir) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// read adds the patterns of the .opdsignore file of dir, when it has one
+func (r ignoreRules) read(fsys fs.FS, dir string) ignoreRules {
+	data, err := fs.ReadFile(fsys, path.Join(dir, ignoreFileName))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("error reading ignore file", "dir", dir, "error", err)
+		}
+		return r
+	}
+	return slices.Concat(r, parseIgnore(dir, strings.Split(string(data), "\n")))
+}
+
+// readFrom is read for a folder that was already listed, it only opens the
+// .opdsignore file when entries has one.
+func (r ignoreRules) readFrom(fsys fs.FS, dir string, entries []fs.DirEntry) ignoreRules {
+	for _, entry := range entries {
+		if entry.Name() == ignoreFileName {
+			return r.read(fsys, dir)
+		}
+	}
+	return r
+}
+
+// ignoreRules returns the rules of the entries of dir: the global patterns and
+// the ones of the .opdsignore files from the root down to dir.
+func (s OPDS) ignoreRules(dir string) ignoreRules {
+	fsys := s.storage()
+	rules := parseIgnore(currentDirectory, s.Ignore).read(fsys, currentDirectory)
+	if dir == currentDirectory {
+		return rules
+	}
+
+	parts := strings.Split(dir, "/")
+	for i := range parts {
+		rules = rules.read(fsys, strings.Join(parts[:i+1], "/"))
+	}
+	return rules
+}
+
+// parentIgnoreRules returns the rules of the folder that holds name, only the
+// global patterns for the root.
+func (s OPDS) parentIgnoreRules(name string) ignoreRules {
+	if name == currentDirectory {
+		return parseIgnore(currentDirectory, s.Ignore)
+	}
+	return s.ignoreRules(path.Dir(name))
+}
+
+// ignored reports whether name, or one of the folders it is in, is ignored
+func (s OPDS) ignored(name string, isDir bool) bool {
+	if name == currentDirectory {
+		return false
+	}
+
+	fsys := s.storage()
+	rules := parseIgnore(currentDirectory, s.Ignore)
+	dir := currentDirectory
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		rules = rules.read(fsys, dir)
+		p := path.Join(dir, part)
+		if controlFile(part) || rules.match(p, isDir || i < len(parts)-1) {
+			return true
+		}
+		dir = p
+	}
+	return false
+}
+
+// ignoreWalker keeps the rules of the folders visited by fs.WalkDir from root
+type ignoreWalker struct {
+	fsys  fs.FS
+	root  string
+	rules map[string]ignoreRules
+}
+
+func (s OPDS) newIgnoreWalker(root string) *ignoreWalker {
+	return &ignoreWalker{
+		fsys:  s.storage(),
+		root:  root,
+		rules: map[string]ignoreRules{root: s.ignoreRules(root)},
+	}
+}
+
+// ignored reports whether the entry name found by fs.WalkDir is ignored,
+// the folders must be skipped when they are.
+func (w *ignoreWalker) ignored(name string, d fs.DirEntry) bool {
+	if name == w.root {
+		return false
+	}
+
+	rules := w.rules[path.Dir(name)]
+	if controlFile(d.Name()) || rules.match(name, d.IsDir()) {
+		return true
+	}
+	if d.IsDir() {
+		w.rules[name] = rules.read(w.fsys, name)
+	}
+	return false
+}
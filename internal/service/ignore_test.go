@@ -0,0 +1,155 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoreRulesMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		base    string
+		name    string
+		isDir   bool
+		ignored bool
+	}{
+		{"*.txt", ".", "notes.txt", false, true},
+		{"*.txt", ".", "a/b/notes.txt", false, true},
+		{"*.txt", ".", "notes.epub", false, false},
+		{"drafts/", ".", "a/drafts", true, true},
+		{"drafts/", ".", "a/drafts", false, false},
+		{"/drafts", ".", "drafts", true, true},
+		{"/drafts", ".", "a/drafts", true, false},
+		{"a/*.pdf", ".", "a/scan.pdf", false, true},
+		{"a/*.pdf", ".", "b/a/scan.pdf", false, false},
+		{"**/tmp", ".", "x/y/tmp", true, true},
+		{"a/**/b", ".", "a/b", true, true},
+		{"a/**/b", ".", "a/x/y/b", true, true},
+		{"a/**", ".", "a", true, false},
+		{"a/**", ".", "a/x/y.epub", false, true},
+		{"*.txt", "comics", "comics/x/notes.txt", false, true},
+		{"*.txt", "comics", "fiction/notes.txt", false, false},
+		{"/extra", "comics", "comics/extra", true, true},
+		{`\#1.epub`, ".", "#1.epub", false, true},
+		{"# comment", ".", "# comment", false, false},
+	}
+	for _, tc := range tests {
+		rules := parseIgnore(tc.base, []string{tc.pattern})
+		assert.Equal(t, tc.ignored, rules.match(tc.name, tc.isDir), "%q in %s matching %s", tc.pattern, tc.base, tc.name)
+	}
+
+	t.Run("negation", func(t *testing.T) {
+		rules := parseIgnore(currentDirectory, []string{"*.pdf", "!keep.pdf"})
+		assert.True(t, rules.match("scan.pdf", false))
+		assert.False(t, rules.match("keep.pdf", false))
+
+		// a deeper .opdsignore overrides the upper one
+		rules = append(rules, parseIgnore("a", []string{"keep.pdf"})...)
+		assert.True(t, rules.match("a/keep.pdf", false))
+		assert.False(t, rules.match("b/keep.pdf", false))
+	})
+
+	assert.True(t, ValidIgnorePattern("**/*.tmp"))
+	assert.False(t, ValidIgnorePattern("[a-"))
+}
+
+func TestOpdsignore(t *testing.T) {
+	s := OPDS{
+		Storage: fstest.MapFS{
+			".opdsignore":                  {Data: []byte("# scans are too big\n*.pdf\n!/keep/*.pdf\ndrafts/\n")},
+			"Under cover.epub":             {Data: []byte("epub")},
+			"scan.pdf":                     {Data: []byte("pdf")},
+			"keep/manual.pdf":              {Data: []byte("pdf")},
+			"drafts/novel.epub":            {Data: []byte("epub")},
+			"scans/one.pdf":                {Data: []byte("pdf")},
+			"scans/two.pdf":                {Data: []byte("pdf")},
+			"scans/more/three.epub":        {Data: []byte("epub")},
+			"comics/.opdsignore":           {Data: []byte("*.txt\n")},
+			"comics/notes.txt":             {Data: []byte("txt")},
+			"comics/Watchmen.cbz":          {Data: []byte("cbz")},
+			"comics/metadata.opf":          {Data: []byte("<package/>")},
+			"comics/extra/making of.txt":   {Data: []byte("txt")},
+			"comics/extra/making of.epub":  {Data: []byte("epub")},
+			"fiction/notes.txt":            {Data: []byte("txt")},
+			"fiction/Dune.txt.backup.epub": {Data: []byte("epub")},
+		},
+		HideCalibreFiles: true,
+		Ignore:           []string{"*.backup.epub"},
+		EnableSearch:     true,
+	}
+
+	names := func(catalog *Catalog) []string {
+		var names []string
+		for _, entry := range catalog.Entries {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+
+	t.Run("Scan", func(t *testing.T) {
+		catalog, err := s.Scan(".", "/", 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"comics", "fiction", "keep", "scans", "Under cover.epub"}, names(catalog))
+
+		catalog, err = s.Scan("comics", "/comics", 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"extra", "Watchmen.cbz"}, names(catalog))
+
+		catalog, err = s.Scan("fiction", "/fiction", 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"notes.txt"}, names(catalog))
+	})
+
+	t.Run("getPathType", func(t *testing.T) {
+		rules := s.ignoreRules(currentDirectory)
+		// only the epub in more/ is left
+		assert.Equal(t, pathTypeDirOfDirs, getPathType(s.Storage, "scans", rules))
+		assert.Equal(t, pathTypeDirOfFiles, getPathType(s.Storage, "keep", rules))
+	})
+
+	t.Run("direct downloads", func(t *testing.T) {
+		for p, code := range map[string]int{
+			"/Under%20cover.epub":           http.StatusOK,
+			"/keep/manual.pdf":              http.StatusOK,
+			"/scan.pdf":                     http.StatusNotFound,
+			"/drafts/":                      http.StatusNotFound,
+			"/drafts/novel.epub":            http.StatusNotFound,
+			"/comics/notes.txt":             http.StatusNotFound,
+			"/comics/.opdsignore":           http.StatusNotFound,
+			"/fiction/notes.txt":            http.StatusOK,
+			"/comics/extra/making%20of.txt": http.StatusNotFound,
+		} {
+			w := httptest.NewRecorder()
+			require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, p, nil)))
+			assert.Equal(t, code, w.Code, p)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		require.NoError(t, s.SearchHandler(w, httptest.NewRequest(http.MethodGet, "/search?q=.", nil)))
+		body := w.Body.String()
+		assert.Contains(t, body, `href="/Under%20cover.epub"`)
+		assert.Contains(t, body, `href="/keep/manual.pdf"`)
+		assert.Contains(t, body, `href="/scans/more/three.epub"`)
+		assert.Contains(t, body, `href="/comics/extra/making%20of.epub"`)
+		assert.NotContains(t, body, "novel.epub")
+		assert.NotContains(t, body, "making%20of.txt")
+		assert.NotContains(t, body, "backup")
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		files, _, err := s.zipFiles("comics", true)
+		require.NoError(t, err)
+		var names []string
+		for _, f := range files {
+			names = append(names, f.name)
+		}
+		assert.Equal(t, []string{"Watchmen.cbz", "extra/making of.epub"}, names)
+	})
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// indexRetry is how long the admin endpoints ask to wait for the index
+const indexRetry = 30 * time.Second
+
+// Index holds the books of a library with their metadata, to relate books
+// that live in different folders and find their duplicates. It is built in
+// the background, from Start or the first use, and the feeds are served
+// without what needs it until it is ready. It is rebuilt the same way once it
+// is older than Refresh while the old one is still served, until Stop.
+type Index struct {
+	Refresh time.Duration
+
+	mu         sync.Mutex
+	current    *indexState
+	refreshing bool
+	stopped    bool
+	cancel     context.CancelFunc
+}
+
+// indexState is a build of an Index
+type indexState struct {
+	entries []CatalogEntry
+	built   time.Time
+
+	// the duplicates are found on first use, it reads the books
+	once       sync.Once
+	duplicates duplicates
+}
+
+// Start builds the index of the library of s in the background
+func (x *Index) Start(s OPDS) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.refresh(s)
+}
+
+// Stop cancels the build in progress and leaves the index as it is, for the
+// libraries of a configuration that was replaced
+func (x *Index) Stop() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.stopped = true
+	if x.cancel != nil {
+		x.cancel()
+	}
+}
+
+// build builds the index and waits for it, for what is not served
+func (x *Index) build(s OPDS) {
+	state := s.buildIndex(context.Background())
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.current = state
+}
+
+// refresh builds the index again in the background unless it is already
+// being built or it was stopped, x.mu must be held
+func (x *Index) refresh(s OPDS) {
+	if x.refreshing || x.stopped {
+		return
+	}
+	x.refreshing = true
+	ctx, cancel := context.WithCancel(context.Background())
+	x.cancel = cancel
+	go func() {
+		defer cancel()
+		state := s.buildIndex(ctx)
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		if ctx.Err() == nil {
+			x.current = state
+		}
+		x.refreshing = false
+	}()
+}
+
+// state returns the current build of the index, nil until the first one is
+// ready
+func (x *Index) state(s OPDS) *indexState {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.current == nil || x.Refresh > 0 && time.Since(x.current.built) > x.Refresh {
+		x.refresh(s)
+	}
+	return x.current
+}
+
+// books returns the books of the library of s, the Name of each one is its
+// name in the storage. It reports false when the index is not ready.
+func (x *Index) books(s OPDS) ([]CatalogEntry, bool) {
+	state := x.state(s)
+	if state == nil {
+		return nil, false
+	}
+	return state.entries, true
+}
+
+// duplicates returns the duplicate books of the library of s. It reports
+// false when the index is not ready.
+func (x *Index) duplicates(s OPDS) (duplicates, bool) {
+	state := x.state(s)
+	if state == nil {
+		return duplicates{}, false
+	}
+	return state.findDuplicates(s), true
+}
+
+func (state *indexState) findDuplicates(s OPDS) duplicates {
+	state.once.Do(func() {
+		state.duplicates = s.findDuplicates(state.entries)
+	})
+	return state.duplicates
+}
+
+// serveIndexNotReady answers the admin endpoints while the index is being
+// built, rather than walking the library again for each request
+func serveIndexNotReady(w http.ResponseWriter) error {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.Itoa(int(indexRetry.Seconds())))
+	http.Error(w, "the index of the library is being built, try again later", http.StatusServiceUnavailable)
+	return nil
+}
+
+// buildIndex indexes the library of s, it stops early when ctx is canceled
+func (s OPDS) buildIndex(ctx context.Context) *indexState {
+	state := &indexState{entries: s.indexBooks(ctx), built: time.Now()}
+	if s.HideDuplicates && ctx.Err() == nil {
+		// the feeds need them, they are found before it is served
+		state.findDuplicates(s)
+	}
+	return state
+}
+
+// indexBooks walks the whole library and reads the metadata of its books,
+// leaving out the files that are hidden or ignored for everybody. It stops
+// early when ctx is canceled.
+func (s OPDS) indexBooks(ctx context.Context) []CatalogEntry {
+	start := time.Now()
+	var entries []CatalogEntry
+
+	fsys := s.storage()
+	walker := s.newIgnoreWalker(currentDirectory)
+	err := fs.WalkDir(fsys, currentDirectory, func(name string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return fs.SkipAll
+		}
+		if err != nil {
+			slog.Error("error indexing the library", "name", name, "error", err)
+			return nil
+		}
+		if name != currentDirectory && fileShouldBeIgnored(d.Name(), s.HideCalibreFiles, s.HideDotFiles) || walker.ignored(name, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		// the covers of the folders are not books
+		if d.IsDir() || s.ShowCovers && (d.Name() == "cover.jpg" || d.Name() == "folder.jpg") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			slog.Error("error getting info for entry", "error", err)
+			return nil
+		}
+		entry := CatalogEntry{
+			Name:    name,
+			Type:    pathTypeFile,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		}
+		if s.ExtractMetadata {
+			entry.setMetadata(extractMetadata(fsys, name))
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		slog.Error("error indexing the library", "error", err)
+	}
+
+	if ctx.Err() != nil {
+		slog.Info("indexing stopped", "prefix", s.Prefix)
+		return nil
+	}
+	slog.Info("library indexed", "prefix", s.Prefix, "books", len(entries), "duration", time.Since(start))
+	return entries
+}
@@ -2,6 +2,12 @@ package service
 
 import (
 	"archive/zip"
+	"bytes"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -52,6 +58,25 @@ func TestInTrustedRoot(t *testing.T) {
 	assert.False(t, inTrustedRoot("/etc/passwd", root))
 }
 
+func TestLocalStorageMissingFiles(t *testing.T) {
+	var buf bytes.Buffer
+	oldLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(oldLogger)
+
+	root, err := filepath.Abs("testdata")
+	require.NoError(t, err)
+
+	_, err = LocalStorage{Root: root}.Stat("mybook/.opdsignore")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	// the .opdsignore files looked up on every request are missing
+	w := httptest.NewRecorder()
+	require.NoError(t, OPDS{TrustedRoot: root}.Handler(w, httptest.NewRequest(http.MethodGet, "/mybook", nil)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, buf.String())
+}
+
 func TestFileShouldBeIgnored(t *testing.T) {
 	assert.False(t, fileShouldBeIgnored("book.epub", true, true))
 	assert.True(t, fileShouldBeIgnored(".hidden", true, true))
@@ -60,6 +85,10 @@ func TestFileShouldBeIgnored(t *testing.T) {
 	assert.False(t, fileShouldBeIgnored("metadata.opf", false, true))
 	assert.False(t, fileShouldBeIgnored(".", true, true))
 	assert.False(t, fileShouldBeIgnored("..", true, true))
+	assert.True(t, fileShouldBeIgnored("cover.jpg", true, true))
+	assert.True(t, fileShouldBeIgnored("metadata.db-journal", true, true))
+	assert.False(t, fileShouldBeIgnored("Under cover.epub", true, true))
+	assert.False(t, fileShouldBeIgnored("The.opf.files.epub", true, true))
 }
 
 func TestSortEntries(t *testing.T) {
@@ -85,15 +114,13 @@ func TestSortEntries(t *testing.T) {
 
 func TestExtractMetadata(t *testing.T) {
 	t.Run("Extract EPUB", func(t *testing.T) {
-		path := filepath.Join("testdata", "mybook", "mybook.epub")
-		title, author, coverPath, description, series, seriesIndex, subjects := extractEpubMetadata(path)
-		t.Logf("EPUB Title: %q, Author: %q, CoverPath: %q, Description: %q, Series: %q, SeriesIndex: %q, Subjects: %v", title, author, coverPath, description, series, seriesIndex, subjects)
+		title, author, coverPath, description, series, seriesIndex, language, subjects := extractEpubMetadata(LocalStorage{Root: "testdata"}, "mybook/mybook.epub")
+		t.Logf("EPUB Title: %q, Author: %q, CoverPath: %q, Description: %q, Series: %q, SeriesIndex: %q, Language: %q, Subjects: %v", title, author, coverPath, description, series, seriesIndex, language, subjects)
 	})
 
 	t.Run("Extract PDF", func(t *testing.T) {
-		path := filepath.Join("testdata", "mybook", "mybook.pdf")
-		title, author, description, subjects := extractPdfMetadata(path)
-		t.Logf("PDF Title: %q, Author: %q, Description: %q, Subjects: %v", title, author, description, subjects)
+		title, author, description, language, subjects := extractPdfMetadata(LocalStorage{Root: "testdata"}, "mybook/mybook.pdf")
+		t.Logf("PDF Title: %q, Author: %q, Description: %q, Language: %q, Subjects: %v", title, author, description, language, subjects)
 	})
 }
 
@@ -125,7 +152,7 @@ func TestPagination(t *testing.T) {
 	s := OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, HideDotFiles: true}
 
 	t.Run("First page", func(t *testing.T) {
-		catalog, err := s.Scan("testdata/mybook", "/mybook", 1)
+		catalog, err := s.Scan("mybook", "/mybook", 1)
 		require.NoError(t, err)
 		assert.Equal(t, 1, catalog.Page)
 		assert.Equal(t, defaultPageSize, catalog.PageSize)
@@ -134,7 +161,7 @@ func TestPagination(t *testing.T) {
 
 	t.Run("Page with small page size", func(t *testing.T) {
 		s.PageSize = 2
-		catalog, err := s.Scan("testdata/mybook", "/mybook", 1)
+		catalog, err := s.Scan("mybook", "/mybook", 1)
 		require.NoError(t, err)
 		assert.Equal(t, 1, catalog.Page)
 		assert.Equal(t, 2, catalog.PageSize)
@@ -144,7 +171,7 @@ func TestPagination(t *testing.T) {
 
 	t.Run("Second page", func(t *testing.T) {
 		s.PageSize = 2
-		catalog, err := s.Scan("testdata/mybook", "/mybook", 2)
+		catalog, err := s.Scan("mybook", "/mybook", 2)
 		require.NoError(t, err)
 		assert.Equal(t, 2, catalog.Page)
 		assert.Equal(t, 5, catalog.Total)
@@ -153,7 +180,7 @@ func TestPagination(t *testing.T) {
 
 	t.Run("Last page with partial entries", func(t *testing.T) {
 		s.PageSize = 2
-		catalog, err := s.Scan("testdata/mybook", "/mybook", 3)
+		catalog, err := s.Scan("mybook", "/mybook", 3)
 		require.NoError(t, err)
 		assert.Equal(t, 3, catalog.Page)
 		assert.Equal(t, 5, catalog.Total)
@@ -162,7 +189,7 @@ func TestPagination(t *testing.T) {
 
 	t.Run("Page beyond total", func(t *testing.T) {
 		s.PageSize = 2
-		catalog, err := s.Scan("testdata/mybook", "/mybook", 100)
+		catalog, err := s.Scan("mybook", "/mybook", 100)
 		require.NoError(t, err)
 		assert.Equal(t, 100, catalog.Page)
 		assert.Empty(t, catalog.Entries)
@@ -229,21 +256,20 @@ func TestEtag(t *testing.T) {
 func TestCatalogModTime(t *testing.T) {
 	s := OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, HideDotFiles: true}
 
-	catalog, err := s.Scan("testdata/mybook", "/mybook", 1)
+	catalog, err := s.Scan("mybook", "/mybook", 1)
 	require.NoError(t, err)
 	assert.False(t, catalog.ModTime.IsZero(), "ModTime should be set")
 }
 
 func TestExtractEpubCover(t *testing.T) {
 	t.Run("Valid EPUB", func(t *testing.T) {
-		path := filepath.Join("testdata", "mybook", "mybook.epub")
-		data, contentType, err := extractEpubCover(path)
+		data, contentType, err := extractEpubCover(LocalStorage{Root: "testdata"}, "mybook/mybook.epub")
 		require.NoError(t, err)
 		t.Logf("Cover content-type: %s, size: %d bytes", contentType, len(data))
 	})
 
 	t.Run("Non-existent file", func(t *testing.T) {
-		_, _, err := extractEpubCover(filepath.Join("testdata", "nonexistent.epub"))
+		_, _, err := extractEpubCover(LocalStorage{Root: "testdata"}, "nonexistent.epub")
 		assert.Error(t, err)
 	})
 }
@@ -264,7 +290,7 @@ func TestFindEpubCover(t *testing.T) {
 		}
 		require.NotEmpty(t, opfPath, "should find OPF file")
 
-		coverPath := findEpubCover(r, nil, opfPath)
+		coverPath := findEpubCover(&r.Reader, nil, opfPath)
 		t.Logf("Found cover path: %q", coverPath)
 	})
 }
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+var libraryNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Library is a collection of books served under its own URL prefix, /Name/.
+// OPDS holds its own root and options, its Prefix must be /Name.
+type Library struct {
+	Name  string
+	Title string
+	OPDS  OPDS
+}
+
+// reservedLibraryNames are the routes served at the top level next to the
+// libraries
+var reservedLibraryNames = []string{"health", "metrics", strings.TrimPrefix(AuthDocumentPath, "/")}
+
+// ValidLibraryName reports whether name can be used as the URL prefix of a
+// library, it can't be the name of a top-level route
+func ValidLibraryName(name string) bool {
+	return libraryNameRegexp.MatchString(name) && !slices.Contains(reservedLibraryNames, name)
+}
+
+// Libraries serves the top-level navigation feed that lists several libraries
+type Libraries struct {
+	BaseURL    string
+	EnableHTML bool
+	NoCache    bool
+	EnableAuth bool
+	ACL        *ACL
+	Libraries  []Library
+}
+
+// catalog builds the Catalog with an entry for every library user can see
+func (l Libraries) catalog(user string) *Catalog {
+	catalog := &Catalog{
+		ID:    "/",
+		Title: "Libraries",
+		Type:  pathTypeDirOfDirs,
+		Page:  1,
+	}
+
+	for _, lib := range l.Libraries {
+		if l.ACL != nil && !l.ACL.Visible(user, "/"+lib.Name) {
+			continue
+		}
+
+		var modTime time.Time
+		if info, err := fs.Stat(lib.OPDS.storage(), currentDirectory); err == nil {
+			modTime = info.ModTime()
+		} else {
+			slog.Error("error reading library root", "library", lib.Name, "error", err)
+		}
+
+		catalog.Entries = append(catalog.Entries, CatalogEntry{
+			Name:    lib.Name,
+			Title:   lib.Title,
+			Type:    lib.OPDS.pathType(currentDirectory, lib.OPDS.parentIgnoreRules(currentDirectory)),
+			ModTime: modTime,
+		})
+		if modTime.After(catalog.ModTime) {
+			catalog.ModTime = modTime
+		}
+	}
+
+	catalog.Total = len(catalog.Entries)
+	catalog.PageSize = max(catalog.Total, 1)
+	return catalog
+}
+
+// Handler serves the list of libraries in the root, any other path is not found
+// because each library is mounted under its own prefix.
+func (l Libraries) Handler(w http.ResponseWriter, req *http.Request) error {
+	if req.URL.Path != "/" {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	if l.NoCache {
+		w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Header().Add("Expires", "0")
+	}
+
+	catalog := l.catalog(UserFromContext(req.Context()))
+	s := OPDS{BaseURL: l.BaseURL, EnableHTML: l.EnableHTML}
+
+	if s.EnableHTML && isBrowser(req) {
+		return s.renderHTML(w, req, catalog)
+	}
+
+	feedBuilder := opds.FeedBuilder.
+		ID(catalog.ID).
+		Title(catalog.Title).
+		Updated(TimeNow()).
+		AddLink(opds.LinkBuilder.Rel("start").Href(s.joinURL("/")).Type(navigationType).Build()).
+		AddLink(opds.LinkBuilder.Rel("self").Href(s.joinURL("/")).Type(navigationType).Build())
+
+	if l.EnableAuth {
+		feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.
+			Rel(authDocumentRel).
+			Href(s.joinURL(AuthDocumentPath)).
+			Type(authDocumentType).
+			Build())
+	}
+
+	for _, entry := range catalog.Entries {
+		title := entry.Name
+		if entry.Title != "" {
+			title = entry.Title
+		}
+
+		feedBuilder = feedBuilder.AddEntry(opds.EntryBuilder.
+			ID("/" + entry.Name + "/").
+			Title(title).
+			Updated(entry.ModTime.UTC()).
+			Published(entry.ModTime.UTC()).
+			AddLink(opds.LinkBuilder.
+				Rel(getRel(entry.Name, entry.Type)).
+				Title(title).
+				Href(s.joinURL("/" + entry.Name + "/")).
+				Type(s.getType(entry.Name, entry.Type)).
+				Build()).
+			Build())
+	}
+
+	navFeed := feedBuilder.Build()
+	navFeed.Opds = "http://opds-spec.org/2010/catalog"
+	content, err := xml.MarshalIndent(navFeed, "  ", "    ")
+	if err != nil {
+		slog.Error("error marshaling feed", "error", err)
+		return err
+	}
+
+	w.Header().Add("Content-Type", navigationType)
+	content = append([]byte(xml.Header), content...)
+	http.ServeContent(w, req, "feed.xml", TimeNow(), bytes.NewReader(content))
+	return nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibrariesHandler(t *testing.T) {
+	modTime := time.Date(2020, 5, 25, 0, 0, 0, 0, time.UTC)
+	comics := fstest.MapFS{
+		"Watchmen/issue1.cbz": {Data: []byte("comic"), ModTime: modTime},
+	}
+
+	l := Libraries{
+		BaseURL: "https://opds.example.com",
+		Libraries: []Library{
+			{Name: "fiction", Title: "Fiction", OPDS: OPDS{TrustedRoot: "testdata/mybook", Prefix: "/fiction"}},
+			{Name: "comics", OPDS: OPDS{Storage: comics, Prefix: "/comics"}},
+		},
+	}
+
+	t.Run("root lists the libraries", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		require.NoError(t, l.Handler(rec, httptest.NewRequest(http.MethodGet, "/", nil)))
+
+		body := rec.Body.String()
+		assert.Equal(t, navigationType, rec.Header().Get("Content-Type"))
+		assert.Contains(t, body, "<title>Libraries</title>")
+		assert.Contains(t, body, `<title>Fiction</title>`)
+		assert.Contains(t, body, `href="https://opds.example.com/fiction/" type="application/atom+xml;profile=opds-catalog;kind=acquisition"`)
+		assert.Contains(t, body, `<title>comics</title>`)
+		assert.Contains(t, body, `href="https://opds.example.com/comics/" type="application/atom+xml;profile=opds-catalog;kind=navigation"`)
+	})
+
+	t.Run("other paths are not found", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		require.NoError(t, l.Handler(rec, httptest.NewRequest(http.MethodGet, "/mybook", nil)))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		l := l
+		l.EnableHTML = true
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", "text/html")
+		require.NoError(t, l.Handler(rec, req))
+		assert.Contains(t, rec.Body.String(), `href="/fiction"`)
+	})
+}
+
+func TestLibraryPrefix(t *testing.T) {
+	s := OPDS{
+		TrustedRoot:      "testdata",
+		Prefix:           "/fiction",
+		HideCalibreFiles: true,
+		HideDotFiles:     true,
+		EnableSearch:     true,
+	}
+
+	t.Run("feed links", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		require.NoError(t, s.Handler(rec, httptest.NewRequest(http.MethodGet, "/", nil)))
+
+		body := rec.Body.String()
+		assert.Contains(t, body, `<id>/fiction/</id>`)
+		assert.Contains(t, body, `<link rel="start" href="/" type="application/atom+xml;profile=opds-catalog;kind=navigation"></link>`)
+		assert.Contains(t, body, `<link rel="up" href="/" type="application/atom+xml;profile=opds-catalog;kind=navigation"></link>`)
+		assert.Contains(t, body, `<link rel="self" href="/fiction/"`)
+		assert.Contains(t, body, `href="/fiction/opensearch.xml"`)
+		assert.Contains(t, body, `href="/fiction/mybook"`)
+	})
+
+	t.Run("subfolder goes up inside the library", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		require.NoError(t, s.Handler(rec, httptest.NewRequest(http.MethodGet, "/mybook", nil)))
+
+		body := rec.Body.String()
+		assert.Contains(t, body, `<link rel="up" href="/fiction/"`)
+		assert.Contains(t, body, `href="/fiction/_entry?file=%2Fmybook%2Fmybook.epub"`, "the books link to their detail page")
+	})
+
+	t.Run("html", func(t *testing.T) {
+		s := s
+		s.EnableHTML = true
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/mybook", nil)
+		req.Header.Set("Accept", "text/html")
+		require.NoError(t, s.Handler(rec, req))
+
+		body := rec.Body.String()
+		assert.Contains(t, body, `action="/fiction/search"`)
+		assert.Contains(t, body, `<a href="/fiction/">fiction</a>`)
+		assert.Contains(t, body, `<a href="/fiction/mybook">mybook</a>`)
+		assert.Contains(t, body, `href="/fiction/_entry?file=%2Fmybook%2Fmybook.epub"`, "the books link to their detail page")
+		assert.Contains(t, body, `<span class="current">Name</span>`)
+		assert.Contains(t, body, `<a href="/fiction/mybook?sort=date">Date</a>`)
+		assert.NotContains(t, body, `sort=author`, "the metadata is not extracted")
+	})
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The metrics of the server, served by MetricsHandler in the Prometheus text
+// format. They are counted by every library of the process.
+var (
+	requestsTotal    = newMetric("dir2opds_requests_total", "Requests served, by route and status code.", "counter", nil, "route", "code")
+	requestDuration  = newMetric("dir2opds_request_duration_seconds", "Time to serve the requests, by route.", "histogram", durationBuckets, "route")
+	requestErrors    = newMetric("dir2opds_request_errors_total", "Requests that failed with an error, by route.", "counter", nil, "route")
+	responseBytes    = newMetric("dir2opds_response_bytes_total", "Bytes of the responses, by route.", "counter", nil, "route")
+	downloadsTotal   = newMetric("dir2opds_downloads_total", "Books downloaded, by format.", "counter", nil, "format")
+	scanDuration     = newMetric("dir2opds_scan_duration_seconds", "Time to scan a folder for its feed.", "histogram", durationBuckets)
+	scanEntries      = newMetric("dir2opds_scan_entries", "Entries of the scanned folders, before the pagination.", "histogram", entriesBuckets)
+	metadataFailures = newMetric("dir2opds_metadata_failures_total", "Extractions of the metadata of the books that failed, by format. A broken book fails on every scan that reads it.", "counter", nil, "format")
+	cacheRequests    = newMetric("dir2opds_cache_requests_total", "Feed requests with -enable-cache, the hits answered with 304 Not Modified and the misses.", "counter", nil, "result")
+)
+
+var (
+	durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	entriesBuckets  = []float64{0, 10, 50, 100, 500, 1000, 5000, 10000}
+)
+
+// metrics holds every metric in the order they are written
+var metrics struct {
+	mu   sync.Mutex
+	list []*metric
+}
+
+// metric is a counter or a histogram with the values of each combination of
+// its labels
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+// series are the values of a metric for some label values
+type series struct {
+	labels []string
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+func newMetric(name, help, kind string, buckets []float64, labels ...string) *metric {
+	m := &metric{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	metrics.list = append(metrics.list, m)
+	return m
+}
+
+// get returns the series of the label values, metrics.mu must be held
+func (m *metric) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: values, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	return s
+}
+
+// add adds v to the counter of the label values
+func (m *metric) add(v float64, values ...string) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	m.get(values).value += v
+}
+
+// inc adds one to the counter of the label values
+func (m *metric) inc(values ...string) {
+	m.add(1, values...)
+}
+
+// observe adds v to the histogram of the label values
+func (m *metric) observe(v float64, values ...string) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	s := m.get(values)
+	for i, bucket := range m.buckets {
+		if v <= bucket {
+			s.counts[i]++
+		}
+	}
+	s.value += v
+	s.count++
+}
+
+// writeMetrics writes every metric in the Prometheus text format
+func writeMetrics(w io.Writer) error {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics.list {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		keys := make([]string, 0, len(m.series))
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			s := m.series[key]
+			if m.kind == "counter" {
+				fmt.Fprintf(bw, "%s%s %s\n", m.name, m.labelSet(s.labels), formatFloat(s.value))
+				continue
+			}
+			for i, bucket := range m.buckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", m.name, m.labelSet(s.labels, "le", formatFloat(bucket)), s.counts[i])
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", m.name, m.labelSet(s.labels, "le", "+Inf"), s.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", m.name, m.labelSet(s.labels), formatFloat(s.value))
+			fmt.Fprintf(bw, "%s_count%s %d\n", m.name, m.labelSet(s.labels), s.count)
+		}
+	}
+	return bw.Flush()
+}
+
+// labelSet returns the labels of the metric with their values, and the extra
+// name and value pairs, like {route="feed",code="200"}
+func (m *metric) labelSet(values []string, extra ...string) string {
+	var pairs []string
+	for i, label := range m.labels {
+		pairs = append(pairs, label+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatOf is the format of the book name for the metrics and statistics, its
+// extension in lowercase
+func formatOf(name string) string {
+	return cmp.Or(strings.ToLower(strings.TrimPrefix(path.Ext(name), ".")), "none")
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	writeMetrics(w)
+}
+
+type routeKey struct{}
+
+// observedRequest is the route of a request, which the handlers can change
+// with setRoute, like the books served by the feed handler
+type observedRequest struct {
+	route string
+}
+
+// setRoute changes the route of the metrics of the request
+func setRoute(req *http.Request, route string) {
+	if o, ok := req.Context().Value(routeKey{}).(*observedRequest); ok {
+		o.route = route
+	}
+}
+
+// RequestError counts a request that failed with an error
+func RequestError(req *http.Request) {
+	if o, ok := req.Context().Value(routeKey{}).(*observedRequest); ok {
+		requestErrors.inc(o.route)
+	}
+}
+
+// Observe wraps the handler of route to count its requests, their latency and
+// the bytes served
+func Observe(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		o := &observedRequest{route: route}
+		ow := &observedWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(ow, req.WithContext(context.WithValue(req.Context(), routeKey{}, o)))
+
+		requestsTotal.inc(o.route, strconv.Itoa(ow.code))
+		requestDuration.observe(time.Since(start).Seconds(), o.route)
+		responseBytes.add(float64(ow.written), o.route)
+	})
+}
+
+// observedWriter keeps the status code and the size of a response
+type observedWriter struct {
+	http.ResponseWriter
+	code        int
+	written     int64
+	wroteHeader bool
+}
+
+func (w *observedWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *observedWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the sendfile of the ResponseWriter for the files copied to
+// the response, like the ones of http.ServeContent
+func (w *observedWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.wroteHeader = true
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, r)
+	}
+	w.written += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *observedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// countDownload counts the download of the book name by the request
+func countDownload(req *http.Request, name string) {
+	setRoute(req, "download")
+	// the other ranges are the rest of a download already counted
+	if r := req.Header.Get("Range"); r == "" || strings.HasPrefix(r, "bytes=0-") {
+		downloadsTotal.inc(formatOf(name))
+	}
+}
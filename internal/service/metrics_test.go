@@ -0,0 +1,147 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	dune := testEpubMetadata(t, `<dc:title>Dune</dc:title>`)
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"shelf/Dune.epub":   {Data: dune},
+			"shelf/broken.epub": {Data: []byte("not a zip")},
+			"shelf/Emma.pdf":    {Data: []byte("not a pdf")},
+		},
+		ExtractMetadata: true,
+		EnableCache:     true,
+	}
+	observe := func(route string, h func(http.ResponseWriter, *http.Request) error) http.Handler {
+		return Observe(route, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if err := h(w, req); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				RequestError(req)
+			}
+		}))
+	}
+	feed := observe("feed", s.Handler)
+	cover := observe("cover", s.CoverHandler)
+
+	// value returns the value of the series of the metrics, 0 when it is missing
+	value := func(t *testing.T, series string) float64 {
+		t.Helper()
+		var buf bytes.Buffer
+		require.NoError(t, writeMetrics(&buf))
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if v, ok := strings.CutPrefix(line, series+" "); ok {
+				f, err := strconv.ParseFloat(v, 64)
+				require.NoError(t, err)
+				return f
+			}
+		}
+		return 0
+	}
+	get := func(h http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	series := []string{
+		`dir2opds_requests_total{route="feed",code="200"}`,
+		`dir2opds_requests_total{route="feed",code="304"}`,
+		`dir2opds_requests_total{route="download",code="200"}`,
+		`dir2opds_request_duration_seconds_count{route="feed"}`,
+		`dir2opds_response_bytes_total{route="download"}`,
+		`dir2opds_downloads_total{format="epub"}`,
+		`dir2opds_scan_duration_seconds_count`,
+		`dir2opds_scan_entries_bucket{le="10"}`,
+		`dir2opds_metadata_failures_total{format="epub"}`,
+		`dir2opds_metadata_failures_total{format="pdf"}`,
+		`dir2opds_cache_requests_total{result="hit"}`,
+		`dir2opds_cache_requests_total{result="miss"}`,
+		`dir2opds_request_errors_total{route="cover"}`,
+		`dir2opds_requests_total{route="cover",code="500"}`,
+	}
+	before := make(map[string]float64)
+	for _, name := range series {
+		before[name] = value(t, name)
+	}
+
+	w := get(feed, "/shelf")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusNotModified, get(feed, "/shelf", "If-None-Match", w.Header().Get("ETag")).Code)
+	assert.Equal(t, http.StatusOK, get(feed, "/shelf/Dune.epub").Code)
+	assert.Equal(t, http.StatusInternalServerError, get(cover, "/cover").Code)
+
+	for name, delta := range map[string]float64{
+		`dir2opds_requests_total{route="feed",code="200"}`:      1,
+		`dir2opds_requests_total{route="feed",code="304"}`:      1,
+		`dir2opds_requests_total{route="download",code="200"}`:  1,
+		`dir2opds_request_duration_seconds_count{route="feed"}`: 2,
+		`dir2opds_response_bytes_total{route="download"}`:       float64(len(dune)),
+		`dir2opds_downloads_total{format="epub"}`:               1,
+		`dir2opds_scan_duration_seconds_count`:                  2,
+		`dir2opds_scan_entries_bucket{le="10"}`:                 2,
+		`dir2opds_metadata_failures_total{format="epub"}`:       2, // on both scans
+		`dir2opds_metadata_failures_total{format="pdf"}`:        2,
+		`dir2opds_cache_requests_total{result="hit"}`:           1,
+		`dir2opds_cache_requests_total{result="miss"}`:          1,
+		`dir2opds_request_errors_total{route="cover"}`:          1,
+		`dir2opds_requests_total{route="cover",code="500"}`:     1,
+	} {
+		assert.Equal(t, delta, value(t, name)-before[name], name)
+	}
+
+	w = httptest.NewRecorder()
+	MetricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, "# HELP dir2opds_requests_total Requests served, by route and status code.\n# TYPE dir2opds_requests_total counter\n")
+	assert.Contains(t, body, "# TYPE dir2opds_request_duration_seconds histogram\n")
+	assert.Contains(t, body, `dir2opds_request_duration_seconds_bucket{route="feed",le="+Inf"} `)
+	assert.Contains(t, body, `dir2opds_request_duration_seconds_sum{route="feed"} `)
+}
+
+func TestObservedWriterReadFrom(t *testing.T) {
+	var readerFrom bool
+	server := httptest.NewServer(Observe("read-from", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, readerFrom = w.(io.ReaderFrom)
+		_, _ = io.Copy(w, strings.NewReader("book"))
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "book", string(body))
+	assert.True(t, readerFrom, "the downloads keep sendfile")
+
+	// without a ReaderFrom below it copies
+	rec := httptest.NewRecorder()
+	ow := &observedWriter{ResponseWriter: rec, code: http.StatusOK}
+	n, err := ow.ReadFrom(strings.NewReader("book"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+	assert.Equal(t, int64(4), ow.written)
+	assert.Equal(t, "book", rec.Body.String())
+}
+
+func TestEscapeLabel(t *testing.T) {
+	assert.Equal(t, `a\"b\\c\nd`, escapeLabel("a\"b\\c\nd"))
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"net/http"
+)
+
+const (
+	// MixedAcquisition presents a folder with books and subfolders as an
+	// acquisition feed, the subfolders are subsection entries among the books
+	MixedAcquisition = "acquisition"
+	// MixedSplit presents a folder with books and subfolders as a navigation
+	// feed of the subfolders, plus an entry that lists the books
+	MixedSplit = "split"
+)
+
+const (
+	// booksParam is the query parameter of the feed of the books of a split folder
+	booksParam = "books"
+	// booksEntryName is the title of the entry that leads to that feed
+	booksEntryName = "Books in this folder"
+)
+
+// ValidMixedFolders reports whether mode is a way of presenting mixed folders
+func ValidMixedFolders(mode string) bool {
+	return mode == MixedAcquisition || mode == MixedSplit
+}
+
+func (s OPDS) splitMixed() bool {
+	return s.MixedFolders == MixedSplit
+}
+
+// getBooksFromQuery reports whether the request asks for the books of a split folder
+func getBooksFromQuery(req *http.Request) bool {
+	return req.URL.Query().Get(booksParam) == "true"
+}
+
+// split moves the books of a mixed folder out of its navigation feed, into
+// the entry returned, or keeps only them when s.BooksOnly is set. It returns
+// nil when the folder has no books.
+func (s OPDS) split(catalog *Catalog, urlPath string) *CatalogEntry {
+	var folders, books []CatalogEntry
+	for _, entry := range catalog.Entries {
+		if entry.Type == pathTypeFile {
+			books = append(books, entry)
+		} else {
+			folders = append(folders, entry)
+		}
+	}
+	if len(books) == 0 {
+		return nil
+	}
+
+	if s.BooksOnly {
+		catalog.ID += "?" + booksParam + "=true"
+		catalog.Type = pathTypeDirOfFiles
+		catalog.Entries = books
+		catalog.Up = urlPath
+		return nil
+	}
+
+	entry := &CatalogEntry{
+		Name:  booksEntryName,
+		Type:  pathTypeDirOfFiles,
+		Query: booksParam + "=true",
+	}
+	for _, book := range books {
+		entry.Size += book.Size
+		if book.ModTime.After(entry.ModTime) {
+			entry.ModTime = book.ModTime
+		}
+	}
+	catalog.Entries = folders
+	return entry
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMixedFolders(t *testing.T) {
+	storage := fstest.MapFS{
+		"mixed/Dune.epub":               {Data: []byte("dune")},
+		"mixed/Emma.epub":               {Data: []byte("emma")},
+		"mixed/series/Part 1.epub":      {Data: []byte("1")},
+		"mixed/.hidden/x.epub":          {Data: []byte("x")},
+		"books/Nana.epub":               {Data: []byte("nana")},
+		"books/.hidden/y.epub":          {Data: []byte("y")},
+		"override/.dir2opds.yaml":       {Data: []byte("mixed: split\n")},
+		"override/Germinal.epub":        {Data: []byte("germinal")},
+		"override/more/Middlemarch.pdf": {Data: []byte("middlemarch")},
+	}
+	t.Run("acquisition", func(t *testing.T) {
+		s := OPDS{Storage: storage, HideDotFiles: true}
+		body := testGet(t, s.Handler, "/mixed", "").Body.String()
+		assert.Contains(t, body, `<link rel="self" href="/mixed" type="application/atom+xml;profile=opds-catalog;kind=acquisition">`)
+		assert.Contains(t, body, "<title>Dune.epub</title>")
+		assert.Contains(t, body, `<link rel="subsection" href="/mixed/series" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="series"></link>`)
+		assert.NotContains(t, body, booksEntryName)
+
+		// the folder config splits its folder
+		body = testGet(t, s.Handler, "/", "").Body.String()
+		assert.Contains(t, body, `<link rel="subsection" href="/override" type="application/atom+xml;profile=opds-catalog;kind=navigation" title="override"></link>`)
+		assert.Contains(t, body, `<link rel="subsection" href="/mixed" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="mixed"></link>`)
+		assert.Contains(t, testGet(t, s.Handler, "/override", "").Body.String(), booksEntryName)
+	})
+
+	t.Run("split", func(t *testing.T) {
+		s := OPDS{Storage: storage, HideDotFiles: true, MixedFolders: MixedSplit}
+
+		body := testGet(t, s.Handler, "/", "").Body.String()
+		assert.Contains(t, body, `<link rel="subsection" href="/mixed" type="application/atom+xml;profile=opds-catalog;kind=navigation" title="mixed"></link>`)
+		assert.Contains(t, body, `<link rel="subsection" href="/books" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="books"></link>`, "hidden folders don't count")
+
+		body = testGet(t, s.Handler, "/mixed", "").Body.String()
+		assert.Contains(t, body, `<link rel="self" href="/mixed" type="application/atom+xml;profile=opds-catalog;kind=navigation">`)
+		assert.Contains(t, body, `<link rel="subsection" href="/mixed?books=true" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="Books in this folder"></link>`)
+		assert.Less(t, strings.Index(body, booksEntryName), strings.Index(body, `href="/mixed/series"`), "the books go first")
+		assert.NotContains(t, body, "Dune.epub")
+
+		body = testGet(t, s.Handler, "/mixed?books=true", "").Body.String()
+		assert.Contains(t, body, `<id>/mixed?books=true</id>`)
+		assert.Contains(t, body, `<link rel="up" href="/mixed" type="application/atom+xml;profile=opds-catalog;kind=navigation"></link>`)
+		assert.Contains(t, body, `href="/mixed/Dune.epub"`)
+		assert.Contains(t, body, `href="/mixed/Emma.epub"`)
+		assert.NotContains(t, body, `href="/mixed/series"`)
+
+		// a folder of books only is not split
+		body = testGet(t, s.Handler, "/books", "").Body.String()
+		assert.Contains(t, body, `href="/books/Nana.epub"`)
+		assert.NotContains(t, body, booksEntryName)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		s := OPDS{Storage: storage, HideDotFiles: true, MixedFolders: MixedSplit, EnableHTML: true}
+		body := testGet(t, s.Handler, "/mixed", "", "Accept", "text/html").Body.String()
+		assert.Contains(t, body, `<a href="/mixed?books=true">Books in this folder</a>`)
+	})
+}
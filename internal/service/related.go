@@ -0,0 +1,122 @@
+package service
+
+import (
+	"cmp"
+	"path"
+	"slices"
+	"strings"
+)
+
+// maxRelatedBooks is the number of books of each kind of relation
+const maxRelatedBooks = 10
+
+// relatedGroup is a list of books related to another one in the same way
+type relatedGroup struct {
+	Title string
+	Books []CatalogEntry
+}
+
+// bookKey identifies a book whatever its format, Dune.epub and Dune.pdf are
+// the same book
+func bookKey(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// related returns the books of the library in the same series as the book
+// name, by the same author and sharing subjects with it, in this order. The
+// next books of the series go first. A book is only in the first group it
+// fits, and once whatever its formats.
+func (s OPDS) related(name string, book CatalogEntry) []relatedGroup {
+	if !s.RelatedBooks || s.Index == nil || !s.ExtractMetadata {
+		return nil
+	}
+
+	books, ok := s.Index.books(s)
+	if !ok {
+		return nil
+	}
+
+	seen := map[string]bool{bookKey(name): true}
+	hidden := s.hiddenDuplicates()
+	var candidates []CatalogEntry
+	for _, e := range books {
+		if !seen[bookKey(e.Name)] && s.allowed("/"+e.Name, false) && !s.hiddenDuplicate(hidden, e.Name) {
+			candidates = append(candidates, e)
+		}
+	}
+
+	c := s.newSorter()
+	byTitle := func(a, b CatalogEntry) int {
+		return c.compare(a.title(), b.title())
+	}
+
+	var groups []relatedGroup
+	add := func(title string, score func(e CatalogEntry) int, compare func(a, b CatalogEntry) int) {
+		type match struct {
+			entry CatalogEntry
+			score int
+		}
+		var matches []match
+		for _, e := range candidates {
+			if n := score(e); n > 0 {
+				matches = append(matches, match{e, n})
+			}
+		}
+		slices.SortFunc(matches, func(a, b match) int {
+			return cmp.Or(cmp.Compare(b.score, a.score), compare(a.entry, b.entry), strings.Compare(a.entry.Name, b.entry.Name))
+		})
+
+		group := relatedGroup{Title: title}
+		for _, m := range matches {
+			key := bookKey(m.entry.Name)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			group.Books = append(group.Books, m.entry)
+			if len(group.Books) == maxRelatedBooks {
+				break
+			}
+		}
+		if len(group.Books) > 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	if book.Series != "" {
+		add("More in "+book.Series, func(e CatalogEntry) int {
+			switch {
+			case !strings.EqualFold(e.Series, book.Series):
+				return 0
+			case compareSeriesIndex(e.SeriesIndex, book.SeriesIndex) > 0:
+				return 2
+			}
+			return 1
+		}, func(a, b CatalogEntry) int {
+			return cmp.Or(compareSeriesIndex(a.SeriesIndex, b.SeriesIndex), byTitle(a, b))
+		})
+	}
+
+	if book.Author != "" {
+		add("More by "+book.Author, func(e CatalogEntry) int {
+			if strings.EqualFold(e.Author, book.Author) {
+				return 1
+			}
+			return 0
+		}, byTitle)
+	}
+
+	if len(book.Subjects) > 0 {
+		add("On the same subjects", func(e CatalogEntry) int {
+			shared := 0
+			for _, subject := range e.Subjects {
+				if slices.ContainsFunc(book.Subjects, func(s string) bool { return strings.EqualFold(s, subject) }) {
+					shared++
+				}
+			}
+			return shared
+		}, byTitle)
+	}
+
+	return groups
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelatedBooks(t *testing.T) {
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"Dune/1 Dune.epub":                   {Data: testBook(t, "Dune", "Frank Herbert", "Dune", "1", "Science fiction")},
+			"Dune/1 Dune.pdf":                    {Data: []byte("%PDF")},
+			"Dune/2 Dune Messiah.epub":           {Data: testBook(t, "Dune Messiah", "Frank Herbert", "Dune", "2")},
+			"Dune/3 Children of Dune.epub":       {Data: testBook(t, "Children of Dune", "Frank Herbert", "Dune", "3")},
+			"Later/4 God Emperor of Dune.epub":   {Data: testBook(t, "God Emperor of Dune", "Frank Herbert", "dune", "4")},
+			"Later/4 God Emperor of Dune.mobi":   {Data: []byte("mobi")},
+			"Herbert/The Dosadi Experiment.epub": {Data: testBook(t, "The Dosadi Experiment", "Frank Herbert", "", "")},
+			"Asimov/Foundation.epub":             {Data: testBook(t, "Foundation", "Isaac Asimov", "", "", "Science fiction", "Empires")},
+			"Austen/Emma.epub":                   {Data: testBook(t, "Emma", "Jane Austen", "", "", "Romance")},
+			"Hidden/.opdsignore":                 {Data: []byte("*\n")},
+			"Hidden/Heretics of Dune.epub":       {Data: testBook(t, "Heretics of Dune", "Frank Herbert", "Dune", "5")},
+		},
+		ExtractMetadata: true,
+		RelatedBooks:    true,
+		Index:           &Index{},
+	}
+	related := func(name, title string) string {
+		return fmt.Sprintf(`<link rel="related" href="%s" type="application/atom+xml;type=entry;profile=opds-catalog" title="%s"></link>`, entryURL(name), title)
+	}
+
+	s.Index.build(s)
+
+	t.Run("index not ready", func(t *testing.T) {
+		s := s
+		// it is being built
+		s.Index = &Index{refreshing: true}
+		assert.Nil(t, s.related("Dune/3 Children of Dune.epub", CatalogEntry{Author: "Frank Herbert", Series: "Dune"}))
+		body := testGet(t, s.EntryHandler, "/_entry?file=%2FDune%2F3+Children+of+Dune.epub", "").Body.String()
+		assert.NotContains(t, body, related("/Dune/1 Dune.epub", "Dune"))
+	})
+
+	t.Run("stopped index", func(t *testing.T) {
+		s := s
+		s.Index = &Index{}
+		s.Index.Stop()
+		s.Index.Start(s)
+		assert.Nil(t, s.related("Dune/3 Children of Dune.epub", CatalogEntry{Author: "Frank Herbert", Series: "Dune"}))
+		assert.False(t, s.Index.refreshing, "it is not built")
+
+		// the build in progress is canceled
+		s.Index = &Index{}
+		s.Index.Start(s)
+		s.Index.Stop()
+		assert.Eventually(t, func() bool {
+			s.Index.mu.Lock()
+			defer s.Index.mu.Unlock()
+			return !s.Index.refreshing
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("groups", func(t *testing.T) {
+		groups := s.related("Dune/3 Children of Dune.epub", CatalogEntry{
+			Author:      "Frank Herbert",
+			Series:      "Dune",
+			SeriesIndex: "3",
+		})
+		require.Len(t, groups, 2)
+
+		var titles []string
+		for _, b := range groups[0].Books {
+			titles = append(titles, b.Title)
+		}
+		assert.Equal(t, "More in Dune", groups[0].Title)
+		assert.Equal(t, []string{"God Emperor of Dune", "Dune", "Dune Messiah"}, titles, "the next books first, each format once")
+
+		assert.Equal(t, "More by Frank Herbert", groups[1].Title)
+		require.Len(t, groups[1].Books, 1)
+		assert.Equal(t, "The Dosadi Experiment", groups[1].Books[0].Title)
+	})
+
+	t.Run("complete entry", func(t *testing.T) {
+		body := testGet(t, s.EntryHandler, "/_entry?file=%2FDune%2F3+Children+of+Dune.epub", "").Body.String()
+		assert.Contains(t, body, related("/Later/4 God Emperor of Dune.epub", "God Emperor of Dune"), "book 4 lives in another folder")
+		assert.Contains(t, body, related("/Dune/1 Dune.epub", "Dune"))
+		assert.Contains(t, body, related("/Herbert/The Dosadi Experiment.epub", "The Dosadi Experiment"))
+		assert.Less(t, strings.Index(body, "God Emperor"), strings.Index(body, "Dosadi"))
+		assert.NotContains(t, body, "Heretics", "ignored books are not related")
+		assert.NotContains(t, body, "Emma")
+
+		// the subjects relate other authors
+		body = testGet(t, s.EntryHandler, "/_entry?file=%2FAsimov%2FFoundation.epub", "").Body.String()
+		assert.Contains(t, body, related("/Dune/1 Dune.epub", "Dune"))
+		assert.NotContains(t, body, "Messiah")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		s := s
+		s.RelatedBooks = false
+		assert.NotContains(t, testGet(t, s.EntryHandler, "/_entry?file=%2FDune%2F3+Children+of+Dune.epub", "").Body.String(), `rel="related" href="/entry`)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		s := s
+		s.EnableHTML = true
+		body := testGet(t, s.EntryHandler, "/_entry?file=%2FDune%2F3+Children+of+Dune.epub", "", "Accept", "text/html").Body.String()
+		assert.Contains(t, body, "<h3>More like this</h3>")
+		assert.Contains(t, body, `<div class="sort">More in Dune</div>`)
+		assert.Contains(t, body, `<a href="/_entry?file=%2FLater%2F4&#43;God&#43;Emperor&#43;of&#43;Dune.epub">God Emperor of Dune</a>`)
+	})
+}
@@ -0,0 +1,582 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Storage is an fs.FS with the books stored in a bucket of an S3 compatible
+// object store like AWS S3 or MinIO. Folders are the common prefixes of the keys,
+// and files support ranged reads so books are never downloaded whole to be served.
+type S3Storage struct {
+	// Endpoint is the URL of the object store, e.g. https://s3.amazonaws.com or http://minio:9000
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is the folder of the bucket used as root of the library
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi s3FileInfo) Name() string       { return fi.name }
+func (fi s3FileInfo) Size() int64        { return fi.size }
+func (fi s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi s3FileInfo) IsDir() bool        { return fi.dir }
+func (fi s3FileInfo) Sys() any           { return nil }
+
+func (fi s3FileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+func (s S3Storage) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+// key returns the object key of name
+func (s S3Storage) key(name string) string {
+	prefix := strings.Trim(s.Prefix, "/")
+	if name == currentDirectory {
+		return prefix
+	}
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+func (s S3Storage) Open(name string) (fs.File, error) {
+	info, err := s.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.dir {
+		return &s3Dir{storage: s, name: name, info: info}, nil
+	}
+	return &s3File{storage: s, key: s.key(name), info: info}, nil
+}
+
+func (s S3Storage) Stat(name string) (fs.FileInfo, error) {
+	info, err := s.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (s S3Storage) stat(op, name string) (s3FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return s3FileInfo{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name == currentDirectory {
+		return s3FileInfo{name: currentDirectory, dir: true}, nil
+	}
+
+	resp, err := s.do(http.MethodHead, s.key(name), nil, nil)
+	if err != nil {
+		return s3FileInfo{}, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return s3FileInfo{name: path.Base(name), size: resp.ContentLength, modTime: modTime}, nil
+	case http.StatusNotFound:
+		// there is no object, but it's a folder if there are keys under it
+		list, err := s.list(s.key(name)+"/", "", 1)
+		if err != nil {
+			return s3FileInfo{}, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if len(list.Contents) == 0 && len(list.CommonPrefixes) == 0 {
+			return s3FileInfo{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		return s3FileInfo{name: path.Base(name), dir: true}, nil
+	default:
+		return s3FileInfo{}, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("s3: unexpected status %s", resp.Status)}
+	}
+}
+
+func (s S3Storage) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	prefix := s.key(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var entries []fs.DirEntry
+	token := ""
+	for {
+		list, err := s.list(prefix, token, 1000)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+
+		for _, p := range list.CommonPrefixes {
+			dirName := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/")
+			if dirName == "" {
+				continue
+			}
+			entries = append(entries, fs.FileInfoToDirEntry(s3FileInfo{name: dirName, dir: true}))
+		}
+		for _, c := range list.Contents {
+			fileName := strings.TrimPrefix(c.Key, prefix)
+			// keys ending with a slash are the folder markers some tools create
+			if fileName == "" || strings.HasSuffix(fileName, "/") {
+				continue
+			}
+			entries = append(entries, fs.FileInfoToDirEntry(s3FileInfo{name: fileName, size: c.Size, modTime: c.LastModified}))
+		}
+
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+
+	if len(entries) == 0 && name != currentDirectory {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// list calls ListObjectsV2 for the keys directly under prefix
+func (s S3Storage) list(prefix, token string, maxKeys int) (*s3ListResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("delimiter", "/")
+	query.Set("prefix", prefix)
+	query.Set("max-keys", strconv.Itoa(maxKeys))
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+
+	resp, err := s.do(http.MethodGet, "", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3: listing %q: unexpected status %s", prefix, resp.Status)
+	}
+
+	var result s3ListResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("s3: decoding list of %q: %w", prefix, err)
+	}
+	return &result, nil
+}
+
+// do sends a signed request for key using path style URLs, which every
+// S3 compatible store understands.
+func (s S3Storage) do(method, key string, query url.Values, header http.Header) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u.Path += "/" + s.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if s.AccessKey != "" {
+		s.sign(req, time.Now())
+	}
+
+	return s.client().Do(req)
+}
+
+// sign adds an AWS Signature Version 4 to the request
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s S3Storage) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	// host, range and the x-amz-* headers are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "range" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape encodes s as required by the canonical request of the signature
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = s3Escape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Dir is a folder opened from an S3Storage
+type s3Dir struct {
+	storage S3Storage
+	name    string
+	info    s3FileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *s3Dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *s3Dir) Close() error               { return nil }
+
+func (d *s3Dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *s3Dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.storage.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// s3BlockSize is the size of the blocks read by ReadAt, the readers of the ZIP
+// and PDF files ask for many small ranges close to each other
+const s3BlockSize = 64 << 10
+
+// s3MaxBlocks is the number of blocks an s3File keeps
+const s3MaxBlocks = 16
+
+// s3File is an object opened from an S3Storage. Reads are ranged GET requests,
+// a sequential read keeps the same response body open until a Seek. ReadAt
+// reads whole blocks and keeps the last ones.
+type s3File struct {
+	storage S3Storage
+	key     string
+	info    s3FileInfo
+	offset  int64
+	body    io.ReadCloser
+
+	mu     sync.Mutex
+	blocks map[int64][]byte
+	// order has the blocks kept, the oldest first
+	order []int64
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		body, err := f.get(f.offset, f.info.size-1)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF && f.offset < f.info.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *s3File) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.info.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p)) - 1
+	if end >= f.info.size {
+		end = f.info.size - 1
+	}
+
+	first, last := off/s3BlockSize, end/s3BlockSize
+	if last-first >= s3MaxBlocks {
+		// a read that big would push out every block
+		return f.readRange(p, off, end)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fetch(first, last); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for i := first; i <= last; i++ {
+		block := f.blocks[i]
+		start := max(off-i*s3BlockSize, 0)
+		n += copy(p[n:], block[start:])
+	}
+	f.evict()
+	n = min(n, int(end-off+1))
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readRange reads the bytes from off to end in p with a single request
+func (f *s3File) readRange(p []byte, off, end int64) (int, error) {
+	body, err := f.get(off, end)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, p[:end-off+1])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// fetch gets the blocks from first to last that are missing with a single
+// request, f.mu must be held
+func (f *s3File) fetch(first, last int64) error {
+	for first <= last && f.blocks[first] != nil {
+		first++
+	}
+	for last >= first && f.blocks[last] != nil {
+		last--
+	}
+	if first > last {
+		return nil
+	}
+
+	start := first * s3BlockSize
+	end := min((last+1)*s3BlockSize, f.info.size) - 1
+	data := make([]byte, end-start+1)
+	if _, err := f.readRange(data, start, end); err != nil {
+		return err
+	}
+
+	if f.blocks == nil {
+		f.blocks = make(map[int64][]byte)
+	}
+	for i := first; i <= last; i++ {
+		if f.blocks[i] == nil {
+			f.order = append(f.order, i)
+		}
+		f.blocks[i] = data[(i-first)*s3BlockSize : min((i-first+1)*s3BlockSize, int64(len(data)))]
+	}
+	return nil
+}
+
+// evict drops the oldest blocks beyond s3MaxBlocks, f.mu must be held
+func (f *s3File) evict() {
+	for len(f.order) > s3MaxBlocks {
+		delete(f.blocks, f.order[0])
+		f.order = f.order[1:]
+	}
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, errors.New("s3: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+func (f *s3File) get(start, end int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := f.storage.do(http.MethodGet, f.key, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3: reading %q: unexpected status %s", f.key, resp.Status)
+	}
+	if resp.StatusCode == http.StatusOK && start > 0 {
+		// the store ignored the range
+		if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return resp.Body, nil
+}
@@ -6,20 +6,20 @@ package service
 import (
 	"archive/zip"
 	"bytes"
+	"cmp"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"mime"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -35,6 +35,7 @@ func init() {
 	_ = mime.AddExtensionType(".cbr", "application/x-cbr")
 	_ = mime.AddExtensionType(".fb2", "text/fb2+xml")
 	_ = mime.AddExtensionType(".pdf", "application/pdf")
+	_ = mime.AddExtensionType(".zip", "application/zip")
 }
 
 const (
@@ -71,18 +72,67 @@ type OPDS struct {
 	BaseURL          string
 	PageSize         int
 	NoPagination     bool
+	// Storage holds the books, when nil they are read from TrustedRoot in the local disk.
+	Storage           fs.FS
+	EnableZipDownload bool
+	ZipMaxSize        int64
+	BrowseArchives    bool
+	// Prefix is the path the library is mounted under when several libraries are served, e.g. /fiction.
+	Prefix string
+	// EnableAuth links the feeds to the OPDS Authentication Document
+	EnableAuth bool
+	// ACL restricts the folders each user can see, User is the user of the request
+	ACL  *ACL
+	User string
+	// Signer signs the links to the books and covers so they work without credentials
+	Signer *URLSigner
+	// Ignore holds gitignore-style patterns of the files to hide, on top of the .opdsignore files
+	Ignore []string
+	// SortLocale is the BCP 47 tag of the language whose rules sort the entries,
+	// SortArticles the leading articles left out when sorting, like The
+	SortLocale   string
+	SortArticles []string
+	// SortOrder is asc or desc, empty for the usual direction of SortBy
+	SortOrder string
+	// Filter holds the values the books of a catalog must have, keyed by the
+	// query parameter of their facet group, like format or lang
+	Filter map[string]string
+	// MixedFolders is how the folders with books and subfolders are presented,
+	// MixedAcquisition when empty, and BooksOnly asks for the books of a split one
+	MixedFolders string
+	BooksOnly    bool
+	// Index holds the books of the whole library for RelatedBooks, the
+	// duplicates and the admin endpoints
+	Index *Index
+	// RelatedBooks links the complete entries to the books of the same
+	// series, author and subjects, it needs Index
+	RelatedBooks bool
+	// HideDuplicates leaves out of the feeds and search all the copies of a
+	// book but the best one, it needs Index
+	HideDuplicates bool
+	// Admins are the users and @groups that can use the admin endpoints, *
+	// for everybody
+	Admins []string
+
+	// static maps the links to the files of a static export, see Export
+	static *staticSite
 }
 
 type Catalog struct {
-	ID       string
-	Title    string
-	Type     int
-	Entries  []CatalogEntry
-	Cover    string
-	Total    int
-	Page     int
-	PageSize int
-	ModTime  time.Time
+	ID          string
+	Title       string
+	Description string
+	Type        int
+	Entries     []CatalogEntry
+	Cover       string
+	Total       int
+	Page        int
+	PageSize    int
+	ModTime     time.Time
+	// Facets are the filters that narrow the entries, with their counts
+	Facets []Facet
+	// Up is where the up link goes when it is not the parent folder
+	Up string
 }
 
 type CatalogEntry struct {
@@ -96,7 +146,39 @@ type CatalogEntry struct {
 	Description string
 	Series      string
 	SeriesIndex string
-	Subjects    []string
+	// Language is the BCP 47 tag of the book, like en or pt-BR
+	Language string
+	Subjects []string
+	// Query makes the entry link to its catalog with this query instead of to
+	// its name, like the books of a split folder
+	Query string
+}
+
+func (e *CatalogEntry) setMetadata(title, author, coverPath, description, series, seriesIndex, language string, subjects []string) {
+	if title != "" {
+		e.Title = title
+	}
+	if author != "" {
+		e.Author = author
+	}
+	if coverPath != "" {
+		e.CoverPath = coverPath
+	}
+	if description != "" {
+		e.Description = description
+	}
+	if series != "" {
+		e.Series = series
+	}
+	if seriesIndex != "" {
+		e.SeriesIndex = seriesIndex
+	}
+	if language != "" {
+		e.Language = language
+	}
+	if len(subjects) > 0 {
+		e.Subjects = subjects
+	}
 }
 
 type IsDirer interface {
@@ -135,12 +217,18 @@ func parsePage(pageStr string) int {
 
 func getSortFromQuery(req *http.Request) string {
 	sortBy := req.URL.Query().Get("sort")
-	switch sortBy {
-	case "name", "date", "size":
+	if ValidSort(sortBy) {
 		return sortBy
-	default:
-		return ""
 	}
+	return ""
+}
+
+func getOrderFromQuery(req *http.Request) string {
+	order := req.URL.Query().Get("order")
+	if ValidSortOrder(order) {
+		return order
+	}
+	return ""
 }
 
 func cloneURLValues(v url.Values) url.Values {
@@ -159,79 +247,146 @@ func etag(urlPath string, modTime time.Time, page int) string {
 	return `"` + hex.EncodeToString(h.Sum(nil))[:16] + `"`
 }
 
-func (s OPDS) Scan(fPath string, urlPath string, page int) (*Catalog, error) {
-	dirEntries, err := os.ReadDir(fPath)
+// Scan inspects the folder name of the storage and builds a Catalog model
+func (s OPDS) Scan(name string, urlPath string, page int) (*Catalog, error) {
+	dirEntries, folder, err := s.listFolder(name)
 	if err != nil {
 		return nil, err
 	}
+	return s.withFolder(folder).scan(name, urlPath, page, folder, dirEntries)
+}
+
+// listFolder returns the entries of the folder name and its sidecar file,
+// only read when the folder has one. The folders of the archives are listed
+// by scan.
+func (s OPDS) listFolder(name string) ([]fs.DirEntry, folderConfig, error) {
+	if _, _, ok := s.splitArchivePath(name); ok {
+		return nil, folderConfig{}, nil
+	}
+	dirEntries, err := fs.ReadDir(s.storage(), name)
+	if err != nil {
+		return nil, folderConfig{}, err
+	}
+	return dirEntries, s.readFolderConfigFrom(name, dirEntries), nil
+}
+
+// scan is Scan with the folder already listed by listFolder
+func (s OPDS) scan(name string, urlPath string, page int, folder folderConfig, dirEntries []fs.DirEntry) (*Catalog, error) {
+	if archivePath, member, ok := s.splitArchivePath(name); ok {
+		return s.scanArchive(archivePath, member, urlPath, page)
+	}
 
-	dirInfo, err := os.Stat(fPath)
+	fsys := s.storage()
+	dirInfo, err := fs.Stat(fsys, name)
 	if err != nil {
 		return nil, err
 	}
 
+	rules := s.parentIgnoreRules(name).readFrom(fsys, name, dirEntries)
+	c := folderClassifier{name: name, rules: rules, archives: s.BrowseArchives, split: s.splitMixed()}
+	c.add(dirEntries)
+
 	catalog := &Catalog{
-		ID:      urlPath,
-		Title:   "Catalog in " + urlPath,
-		Type:    getPathType(fPath),
-		ModTime: dirInfo.ModTime(),
+		ID:          s.mountPath(urlPath),
+		Title:       "Catalog in " + s.mountPath(urlPath),
+		Description: folder.Description,
+		Type:        folder.pathType(c.pathType()),
+		ModTime:     dirInfo.ModTime(),
+	}
+	if folder.Title != "" {
+		catalog.Title = folder.Title
 	}
 
+	configs := map[string]folderConfig{}
+	hidden := s.hiddenDuplicates()
 	for _, entry := range dirEntries {
-		if fileShouldBeIgnored(entry.Name(), s.HideCalibreFiles, s.HideDotFiles) {
+		entryPath := path.Join(name, entry.Name())
+		if entry.Name() == folderConfigName {
+			// editing the sidecar file changes the feed
+			if info, err := entry.Info(); err == nil && info.ModTime().After(catalog.ModTime) {
+				catalog.ModTime = info.ModTime()
+			}
+		}
+		if fileShouldBeIgnored(entry.Name(), s.HideCalibreFiles, s.HideDotFiles) ||
+			controlFile(entry.Name()) || rules.match(entryPath, !isFile(entry)) {
+			continue
+		}
+
+		if entry.Name() == folder.Cover {
 			continue
 		}
 
 		if s.ShowCovers && (entry.Name() == "cover.jpg" || entry.Name() == "folder.jpg") {
-			catalog.Cover = filepath.Join(urlPath, entry.Name())
+			catalog.Cover = path.Join(urlPath, entry.Name())
 			continue
 		}
 
-		entryPath := filepath.Join(fPath, entry.Name())
 		info, err := entry.Info()
 		if err != nil {
 			slog.Error("error getting info for entry", "error", err)
 			continue
 		}
 
+		isFolder := entry.IsDir() || s.BrowseArchives && isArchive(entry.Name())
+		if !s.allowed(path.Join(urlPath, entry.Name()), isFolder) || !isFolder && s.hiddenDuplicate(hidden, entryPath) {
+			continue
+		}
+
+		// the folders are read once they are on the page, by classify
+		entryType := pathTypeFile
+		if isFolder {
+			entryType = pathTypeDirOfDirs
+		}
 		catalog.Entries = append(catalog.Entries, CatalogEntry{
 			Name:    entry.Name(),
-			Type:    getPathType(entryPath),
+			Type:    entryType,
 			ModTime: info.ModTime(),
 			Size:    info.Size(),
 		})
 
+		// the titles of the folders in their sidecar files are needed to sort them
+		if entry.IsDir() && s.sortsByTitle() {
+			sub := s.readFolderConfig(entryPath)
+			configs[entry.Name()] = sub
+			catalog.Entries[len(catalog.Entries)-1].Title = sub.Title
+		}
+
 		if info.ModTime().After(catalog.ModTime) {
 			catalog.ModTime = info.ModTime()
 		}
 
-		if s.ExtractMetadata && !entry.IsDir() {
+		if s.ExtractMetadata && entryType == pathTypeFile {
 			idx := len(catalog.Entries) - 1
-			title, author, coverPath, description, series, seriesIndex, subjects := extractMetadata(entryPath)
-			if title != "" {
-				catalog.Entries[idx].Title = title
-			}
-			if author != "" {
-				catalog.Entries[idx].Author = author
-			}
-			if coverPath != "" {
-				catalog.Entries[idx].CoverPath = coverPath
-			}
-			if description != "" {
-				catalog.Entries[idx].Description = description
-			}
-			if series != "" {
-				catalog.Entries[idx].Series = series
-			}
-			if seriesIndex != "" {
-				catalog.Entries[idx].SeriesIndex = seriesIndex
-			}
-			if len(subjects) > 0 {
-				catalog.Entries[idx].Subjects = subjects
-			}
+			catalog.Entries[idx].setMetadata(extractMetadata(fsys, entryPath))
 		}
 	}
 
+	if folder.Cover != "" {
+		catalog.Cover = path.Join(urlPath, folder.Cover)
+	}
+
+	var books *CatalogEntry
+	if s.splitMixed() && catalog.Type == pathTypeDirOfDirs {
+		books = s.split(catalog, urlPath)
+	}
+
+	s.paginate(catalog, page)
+	s.classify(catalog, name, rules, configs)
+
+	// the books of a split folder go first, out of the pages of the folders
+	if books != nil && catalog.Page == 1 {
+		catalog.Entries = append([]CatalogEntry{*books}, catalog.Entries...)
+	}
+
+	return catalog, nil
+}
+
+// paginate filters and sorts the entries of the catalog and keeps the ones in page
+func (s OPDS) paginate(catalog *Catalog, page int) {
+	catalog.Facets, catalog.Entries = s.filter(catalog.Entries)
+	if s.static != nil {
+		catalog.Facets = nil
+	}
 	s.sortEntries(catalog.Entries)
 
 	total := len(catalog.Entries)
@@ -261,50 +416,83 @@ func (s OPDS) Scan(fPath string, urlPath string, page int) (*Catalog, error) {
 	catalog.Page = page
 	catalog.PageSize = pageSize
 	catalog.Entries = catalog.Entries[start:end]
+}
 
-	return catalog, nil
+func extractMetadata(fsys fs.FS, name string) (string, string, string, string, string, string, string, []string) {
+	if !hasMetadata(name) {
+		return "", "", "", "", "", "", "", nil
+	}
+
+	f, err := openFile(fsys, name)
+	if err != nil {
+		metadataFailures.inc(formatOf(name))
+		return "", "", "", "", "", "", "", nil
+	}
+	defer f.Close()
+
+	return extractMetadataFrom(name, f, f.info.Size())
 }
 
-func extractMetadata(path string) (string, string, string, string, string, string, []string) {
-	ext := strings.ToLower(filepath.Ext(path))
+// extractMetadataFrom works like extractMetadata for a book that is already open,
+// like a member of a ZIP archive.
+func extractMetadataFrom(name string, r io.ReaderAt, size int64) (string, string, string, string, string, string, string, []string) {
+	ext := strings.ToLower(filepath.Ext(name))
 	switch ext {
 	case ".epub":
-		return extractEpubMetadata(path)
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			metadataFailures.inc("epub")
+			return "", "", "", "", "", "", "", nil
+		}
+		return extractEpubMetadataFrom(zr)
 	case ".pdf":
-		title, author, description, subjects := extractPdfMetadata(path)
-		return title, author, "", description, "", "", subjects
+		title, author, description, language, subjects := extractPdfMetadataFrom(r, size)
+		return title, author, "", description, "", "", language, subjects
 	}
-	return "", "", "", "", "", "", nil
+	return "", "", "", "", "", "", "", nil
 }
 
-func extractEpubMetadata(path string) (string, string, string, string, string, string, []string) {
-	r, err := zip.OpenReader(path)
+func extractEpubMetadata(fsys fs.FS, name string) (string, string, string, string, string, string, string, []string) {
+	f, err := openFile(fsys, name)
 	if err != nil {
-		return "", "", "", "", "", "", nil
+		return "", "", "", "", "", "", "", nil
 	}
-	defer r.Close()
+	defer f.Close()
 
-	var opfPath string
-	for _, f := range r.File {
-		if strings.HasSuffix(f.Name, ".opf") {
-			opfPath = f.Name
-			break
-		}
+	r, err := zip.NewReader(f, f.info.Size())
+	if err != nil {
+		return "", "", "", "", "", "", "", nil
 	}
 
-	if opfPath == "" {
-		return "", "", "", "", "", "", nil
-	}
+	return extractEpubMetadataFrom(r)
+}
 
-	f, err := r.Open(opfPath)
-	if err != nil {
-		return "", "", "", "", "", "", nil
+// errNoOPF is returned for the EPUBs without a package document
+var errNoOPF = errors.New("no .opf file")
+
+// epubOPF returns the path and the content of the package document of an EPUB,
+// the .opf file with its metadata
+func epubOPF(r *zip.Reader) (string, []byte, error) {
+	for _, f := range r.File {
+		if strings.HasSuffix(f.Name, ".opf") {
+			rc, err := f.Open()
+			if err != nil {
+				return f.Name, nil, err
+			}
+			defer rc.Close()
+
+			content, err := io.ReadAll(rc)
+			return f.Name, content, err
+		}
 	}
-	defer f.Close()
+	return "", nil, errNoOPF
+}
 
-	opfContent, err := io.ReadAll(f)
+func extractEpubMetadataFrom(r *zip.Reader) (string, string, string, string, string, string, string, []string) {
+	opfPath, opfContent, err := epubOPF(r)
 	if err != nil {
-		return "", "", "", "", "", "", nil
+		metadataFailures.inc("epub")
+		return "", "", "", "", "", "", "", nil
 	}
 
 	var opf struct {
@@ -312,6 +500,7 @@ func extractEpubMetadata(path string) (string, string, string, string, string, s
 			Title       string   `xml:"title"`
 			Creator     string   `xml:"creator"`
 			Description string   `xml:"description"`
+			Language    string   `xml:"language"`
 			Subject     []string `xml:"subject"`
 			Meta        []struct {
 				Name    string `xml:"name,attr"`
@@ -329,7 +518,8 @@ func extractEpubMetadata(path string) (string, string, string, string, string, s
 
 	decoder := xml.NewDecoder(bytes.NewReader(opfContent))
 	if err := decoder.Decode(&opf); err != nil {
-		return "", "", "", "", "", "", nil
+		metadataFailures.inc("epub")
+		return "", "", "", "", "", "", "", nil
 	}
 
 	// If standard unmarshal fails to get values due to namespaces
@@ -341,6 +531,7 @@ func extractEpubMetadata(path string) (string, string, string, string, string, s
 				Title       string   `xml:"title"`
 				Creator     string   `xml:"creator"`
 				Description string   `xml:"description"`
+				Language    string   `xml:"language"`
 				Subject     []string `xml:"subject"`
 				Meta        []struct {
 					Name    string `xml:"name,attr"`
@@ -358,6 +549,9 @@ func extractEpubMetadata(path string) (string, string, string, string, string, s
 		if opf2.Metadata.Description != "" {
 			opf.Metadata.Description = opf2.Metadata.Description
 		}
+		if opf2.Metadata.Language != "" && opf.Metadata.Language == "" {
+			opf.Metadata.Language = opf2.Metadata.Language
+		}
 		if len(opf2.Metadata.Subject) > 0 && len(opf.Metadata.Subject) == 0 {
 			opf.Metadata.Subject = opf2.Metadata.Subject
 		}
@@ -379,10 +573,10 @@ func extractEpubMetadata(path string) (string, string, string, string, string, s
 	// Find cover image in manifest
 	coverPath := findEpubCover(r, opf.Manifest.Items, opfPath)
 
-	return opf.Metadata.Title, opf.Metadata.Creator, coverPath, opf.Metadata.Description, series, seriesIndex, opf.Metadata.Subject
+	return opf.Metadata.Title, opf.Metadata.Creator, coverPath, opf.Metadata.Description, series, seriesIndex, strings.TrimSpace(opf.Metadata.Language), opf.Metadata.Subject
 }
 
-func findEpubCover(r *zip.ReadCloser, items []struct {
+func findEpubCover(r *zip.Reader, items []struct {
 	ID        string `xml:"id,attr"`
 	Href      string `xml:"href,attr"`
 	MediaType string `xml:"media-type,attr"`
@@ -437,15 +631,29 @@ func findEpubCover(r *zip.ReadCloser, items []struct {
 	return ""
 }
 
-func extractPdfMetadata(path string) (string, string, string, []string) {
-	reader, err := pdf.Open(path)
+func extractPdfMetadata(fsys fs.FS, name string) (string, string, string, string, []string) {
+	f, err := openFile(fsys, name)
+	if err != nil {
+		return "", "", "", "", nil
+	}
+	defer f.Close()
+
+	return extractPdfMetadataFrom(f, f.info.Size())
+}
+
+func extractPdfMetadataFrom(r io.ReaderAt, size int64) (string, string, string, string, []string) {
+	reader, err := pdf.NewReader(r, size)
 	if err != nil {
-		return "", "", "", nil
+		metadataFailures.inc("pdf")
+		return "", "", "", "", nil
 	}
 
+	// the language is in the document catalog, not in the info dictionary
+	language := reader.Trailer().Key("Root").Key("Lang").Text()
+
 	info := reader.Trailer().Key("Info")
 	if info.IsNull() {
-		return "", "", "", nil
+		return "", "", "", language, nil
 	}
 
 	title := info.Key("Title").Text()
@@ -461,24 +669,7 @@ func extractPdfMetadata(path string) (string, string, string, []string) {
 		}
 	}
 
-	return title, author, description, subjects
-}
-
-func (s OPDS) sortEntries(entries []CatalogEntry) {
-	switch s.SortBy {
-	case "date":
-		sort.Slice(entries, func(i, j int) bool {
-			return entries[i].ModTime.After(entries[j].ModTime)
-		})
-	case "size":
-		sort.Slice(entries, func(i, j int) bool {
-			return entries[i].Size > entries[j].Size
-		})
-	default: // name
-		sort.Slice(entries, func(i, j int) bool {
-			return entries[i].Name < entries[j].Name
-		})
-	}
+	return title, author, description, language, subjects
 }
 
 func isBrowser(r *http.Request) bool {
@@ -490,6 +681,8 @@ func isBrowser(r *http.Request) bool {
 // returns an Acquisition Feed when the entries are documents or
 // returns a Navigation Feed when the entries are other folders
 func (s OPDS) Handler(w http.ResponseWriter, req *http.Request) error {
+	s.User = UserFromContext(req.Context())
+
 	var err error
 	urlPath, err := url.PathUnescape(req.URL.Path)
 	if err != nil {
@@ -497,51 +690,96 @@ func (s OPDS) Handler(w http.ResponseWriter, req *http.Request) error {
 		return err
 	}
 
-	fPath := filepath.Join(s.TrustedRoot, urlPath)
-
-	// verifyPath avoid the http transversal by checking the path is under DirRoot
-	_, err = verifyPath(fPath, s.TrustedRoot)
-	if err != nil {
-		slog.Error("verify path error", "error", err)
+	// storagePath avoid the http transversal by checking the path is under the root of the storage
+	name, ok := storagePath(urlPath)
+	if !ok {
+		slog.Error("verify path error", "urlPath", urlPath)
 		w.WriteHeader(http.StatusNotFound)
 		return nil
 	}
 
-	if _, err := os.Stat(fPath); err != nil {
+	if archivePath, member, ok := s.splitArchivePath(name); ok {
+		if s.ignored(archivePath, false) {
+			w.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+		return s.archiveHandler(w, req, name, urlPath, archivePath, member)
+	}
+
+	info, err := fs.Stat(s.storage(), name)
+	if err != nil {
 		slog.Error("file system stat error", "error", err)
 		w.WriteHeader(http.StatusNotFound)
 		return nil
 	}
 
-	pathType := getPathType(fPath)
+	// what the user can't see, or is ignored, doesn't exist for them
+	if !s.allowed(urlPath, info.IsDir()) || s.ignored(name, info.IsDir()) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
 
 	// it's a file just serve the file
-	if pathType == pathTypeFile {
-		http.ServeFile(w, req, fPath)
+	if !info.IsDir() {
+		return s.serveFile(w, req, name)
+	}
+
+	return s.serveCatalog(w, req, name, urlPath)
+}
+
+// serveFile serves the content of a book, ranges included
+func (s OPDS) serveFile(w http.ResponseWriter, req *http.Request, name string) error {
+	f, err := openFile(s.storage(), name)
+	if err != nil {
+		slog.Error("error opening file", "name", name, "error", err)
+		w.WriteHeader(http.StatusNotFound)
 		return nil
 	}
+	defer f.Close()
+
+	countDownload(req, name)
+	http.ServeContent(w, req, f.info.Name(), f.info.ModTime(), f)
+	return nil
+}
 
+// serveCatalog returns the feed, or the HTML view, of the folder name
+func (s OPDS) serveCatalog(w http.ResponseWriter, req *http.Request, name, urlPath string) error {
 	if s.NoCache {
 		w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
 		w.Header().Add("Expires", "0")
 	}
 
 	page := parsePage(req.URL.Query().Get("page"))
+	dirEntries, folder, err := s.listFolder(name)
+	if err != nil {
+		slog.Error("error scanning path", "error", err)
+		return err
+	}
+	// the sort of the request wins over the one of the folder
+	s = s.withFolder(folder)
 	sortBy := getSortFromQuery(req)
 	if sortBy != "" {
 		s.SortBy = sortBy
 	}
+	if order := getOrderFromQuery(req); order != "" {
+		s.SortOrder = order
+	}
+	s.Filter = getFilterFromQuery(req)
+	s.BooksOnly = getBooksFromQuery(req)
 
 	complete := req.URL.Query().Get("complete") == "true"
 	if complete {
 		s.NoPagination = true
 	}
 
-	catalog, err := s.Scan(fPath, urlPath, page)
+	start := time.Now()
+	catalog, err := s.scan(name, urlPath, page, folder, dirEntries)
 	if err != nil {
 		slog.Error("error scanning path", "error", err)
 		return err
 	}
+	scanDuration.observe(time.Since(start).Seconds())
+	scanEntries.observe(float64(catalog.Total))
 
 	slog.Debug("request",
 		"urlPath", urlPath,
@@ -552,14 +790,27 @@ func (s OPDS) Handler(w http.ResponseWriter, req *http.Request) error {
 	)
 
 	if s.EnableCache {
-		eTag := etag(urlPath, catalog.ModTime, page)
+		// with an ACL each user may see a different listing of the same folder,
+		// and the signed links change with the user and the hour
+		key := urlPath
+		if s.ACL != nil || s.Signer != nil {
+			key = s.User + ":" + urlPath
+		}
 		lastModified := catalog.ModTime.UTC()
+		if s.Signer != nil {
+			key += ":" + strconv.FormatInt(s.Signer.expires().Unix(), 10)
+			if window := s.Signer.window(); window.After(lastModified) {
+				lastModified = window
+			}
+		}
+		eTag := etag(key, catalog.ModTime, page)
 
 		w.Header().Set("ETag", eTag)
 		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
 
 		if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
 			if ifNoneMatch == eTag {
+				cacheRequests.inc("hit")
 				w.WriteHeader(http.StatusNotModified)
 				return nil
 			}
@@ -568,11 +819,13 @@ func (s OPDS) Handler(w http.ResponseWriter, req *http.Request) error {
 		if ifModifiedSince := req.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
 			if t, err := time.Parse(http.TimeFormat, ifModifiedSince); err == nil {
 				if !lastModified.After(t) {
+					cacheRequests.inc("hit")
 					w.WriteHeader(http.StatusNotModified)
 					return nil
 				}
 			}
 		}
+		cacheRequests.inc("miss")
 	}
 
 	if s.EnableHTML && isBrowser(req) {
@@ -610,6 +863,7 @@ func (s OPDS) SearchHandler(w http.ResponseWriter, req *http.Request) error {
 	if query == "" {
 		return s.Handler(w, req)
 	}
+	s.User = UserFromContext(req.Context())
 
 	page := parsePage(req.URL.Query().Get("page"))
 	pageSize := s.pageSize()
@@ -618,6 +872,9 @@ func (s OPDS) SearchHandler(w http.ResponseWriter, req *http.Request) error {
 	if sortBy != "" {
 		s.SortBy = sortBy
 	}
+	if order := getOrderFromQuery(req); order != "" {
+		s.SortOrder = order
+	}
 
 	catalog := &Catalog{
 		ID:    "search:" + query,
@@ -625,21 +882,32 @@ func (s OPDS) SearchHandler(w http.ResponseWriter, req *http.Request) error {
 		Type:  pathTypeDirOfFiles,
 	}
 
-	err := filepath.Walk(s.TrustedRoot, func(path string, info os.FileInfo, err error) error {
+	hidden := s.hiddenDuplicates()
+	walker := s.newIgnoreWalker(currentDirectory)
+	err := fs.WalkDir(s.storage(), currentDirectory, func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if fileShouldBeIgnored(info.Name(), s.HideCalibreFiles, s.HideDotFiles) {
-			if info.IsDir() {
-				return filepath.SkipDir
+		if name != currentDirectory && fileShouldBeIgnored(d.Name(), s.HideCalibreFiles, s.HideDotFiles) || walker.ignored(name, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !s.allowed("/"+name, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
 			}
 			return nil
 		}
 
-		if !info.IsDir() && strings.Contains(strings.ToLower(info.Name()), strings.ToLower(query)) {
-			relPath, _ := filepath.Rel(s.TrustedRoot, path)
+		if !d.IsDir() && strings.Contains(strings.ToLower(d.Name()), strings.ToLower(query)) && !s.hiddenDuplicate(hidden, name) {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
 			catalog.Entries = append(catalog.Entries, CatalogEntry{
-				Name:    relPath,
+				Name:    name,
 				Type:    pathTypeFile,
 				ModTime: info.ModTime(),
 				Size:    info.Size(),
@@ -674,12 +942,12 @@ func (s OPDS) SearchHandler(w http.ResponseWriter, req *http.Request) error {
 	}
 
 	navFeed := s.makeFeed(catalog, req)
-		navFeed.Opds = "http://opds-spec.org/2010/catalog"
-		acFeed := &opds.AcquisitionFeed{Feed: &navFeed, Dc: "http://purl.org/dc/terms/"}
-		content, err := xml.MarshalIndent(acFeed, "  ", "    ")
-		if err != nil {
-			return err
-		}
+	navFeed.Opds = "http://opds-spec.org/2010/catalog"
+	acFeed := &opds.AcquisitionFeed{Feed: &navFeed, Dc: "http://purl.org/dc/terms/"}
+	content, err := xml.MarshalIndent(acFeed, "  ", "    ")
+	if err != nil {
+		return err
+	}
 
 	w.Header().Add("Content-Type", "application/atom+xml;profile=opds-catalog;kind=acquisition")
 	content = append([]byte(xml.Header), content...)
@@ -703,14 +971,31 @@ func (s OPDS) OpenSearchHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 func (s OPDS) joinURL(p string) string {
-	if s.BaseURL == "" {
+	if s.static != nil {
+		p = s.static.link(s, p, false)
+	}
+	return joinBaseURL(s.BaseURL, s.mountPath(p))
+}
+
+// mountPath prepends the Prefix of the library to a path of the library
+func (s OPDS) mountPath(p string) string {
+	if s.Prefix == "" {
+		return p
+	}
+	return strings.TrimSuffix(s.Prefix, "/") + "/" + strings.TrimPrefix(p, "/")
+}
+
+func joinBaseURL(baseURL, p string) string {
+	if baseURL == "" {
 		return p
 	}
-	return strings.TrimSuffix(s.BaseURL, "/") + "/" + strings.TrimPrefix(p, "/")
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(p, "/")
 }
 
 // CoverHandler extracts and serves cover images from EPUB files
 func (s OPDS) CoverHandler(w http.ResponseWriter, req *http.Request) error {
+	s.User = UserFromContext(req.Context())
+
 	filePath := req.URL.Query().Get("file")
 	if filePath == "" {
 		return fmt.Errorf("missing file parameter")
@@ -722,25 +1007,55 @@ func (s OPDS) CoverHandler(w http.ResponseWriter, req *http.Request) error {
 		return err
 	}
 
-	fPath := filepath.Join(s.TrustedRoot, urlPath)
+	// storagePath avoid the http transversal by checking the path is under the root of the storage
+	name, ok := storagePath(urlPath)
+	if !ok {
+		slog.Error("verify path error for cover", "urlPath", urlPath)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
 
-	// verifyPath avoid the http transversal by checking the path is under TrustedRoot
-	_, err = verifyPath(fPath, s.TrustedRoot)
-	if err != nil {
-		slog.Error("verify path error for cover", "error", err)
+	if !s.allowed(urlPath, false) {
 		w.WriteHeader(http.StatusNotFound)
 		return nil
 	}
 
-	if _, err := os.Stat(fPath); err != nil {
-		slog.Error("file stat error for cover", "error", err)
+	bookPath := name
+	if archivePath, _, ok := s.splitArchivePath(name); ok {
+		bookPath = archivePath
+	}
+	if s.ignored(bookPath, false) {
 		w.WriteHeader(http.StatusNotFound)
 		return nil
 	}
 
-	coverData, contentType, err := extractEpubCover(fPath)
+	var coverData []byte
+	var contentType string
+	if archivePath, member, ok := s.splitArchivePath(name); ok {
+		a, f, err := s.openArchiveMember(archivePath, member)
+		if err != nil {
+			slog.Error("error opening archive member for cover", "error", err)
+			w.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+		defer a.Close()
+
+		coverData, contentType, err = a.epubCover(f)
+		if err != nil {
+			slog.Error("error extracting cover", "name", name, "error", err)
+			return err
+		}
+	} else {
+		if _, err := fs.Stat(s.storage(), name); err != nil {
+			slog.Error("file stat error for cover", "error", err)
+			w.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+
+		coverData, contentType, err = extractEpubCover(s.storage(), name)
+	}
 	if err != nil {
-		slog.Error("error extracting cover", "path", fPath, "error", err)
+		slog.Error("error extracting cover", "name", name, "error", err)
 		return err
 	}
 
@@ -756,13 +1071,23 @@ func (s OPDS) CoverHandler(w http.ResponseWriter, req *http.Request) error {
 }
 
 // extractEpubCover extracts the cover image from an EPUB file
-func extractEpubCover(epubPath string) ([]byte, string, error) {
-	r, err := zip.OpenReader(epubPath)
+func extractEpubCover(fsys fs.FS, name string) ([]byte, string, error) {
+	f, err := openFile(fsys, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("opening epub: %w", err)
+	}
+	defer f.Close()
+
+	r, err := zip.NewReader(f, f.info.Size())
 	if err != nil {
 		return nil, "", fmt.Errorf("opening epub: %w", err)
 	}
-	defer r.Close()
 
+	return extractEpubCoverFrom(r)
+}
+
+// extractEpubCoverFrom extracts the cover image from an already opened EPUB
+func extractEpubCoverFrom(r *zip.Reader) ([]byte, string, error) {
 	var opfPath string
 	for _, f := range r.File {
 		if strings.HasSuffix(f.Name, ".opf") {
@@ -836,10 +1161,21 @@ func (s OPDS) makeFeed(catalog *Catalog, req *http.Request) opds.Feed {
 		ID(catalog.ID).
 		Title(catalog.Title).
 		Updated(TimeNow()).
-		AddLink(opds.LinkBuilder.Rel("start").Href(s.joinURL("/")).Type(navigationType).Build()).
+		AddLink(opds.LinkBuilder.Rel("start").Href(s.startURL()).Type(navigationType).Build()).
 		AddLink(opds.LinkBuilder.Rel("self").Href(s.joinURL(req.URL.Path)).Type(feedType).Build())
 
-	if req.URL.Path != "/" && req.URL.Path != "" {
+	if catalog.Description != "" {
+		subtitle := opds.TextBuilder.Body(catalog.Description).Build()
+		feedBuilder = feedBuilder.Subtitle(&subtitle)
+	}
+
+	if catalog.Up != "" {
+		feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.
+			Rel("up").
+			Href(s.joinURL(catalog.Up)).
+			Type(navigationType).
+			Build())
+	} else if req.URL.Path != "/" && req.URL.Path != "" {
 		parentPath := path.Dir(req.URL.Path)
 		if parentPath == "." {
 			parentPath = "/"
@@ -849,6 +1185,21 @@ func (s OPDS) makeFeed(catalog *Catalog, req *http.Request) opds.Feed {
 			Href(s.joinURL(parentPath)).
 			Type(navigationType).
 			Build())
+	} else if s.Prefix != "" {
+		// the root of a library goes up to the list of libraries
+		feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.
+			Rel("up").
+			Href(joinBaseURL(s.BaseURL, "/")).
+			Type(navigationType).
+			Build())
+	}
+
+	if s.EnableAuth {
+		feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.
+			Rel(authDocumentRel).
+			Href(joinBaseURL(s.BaseURL, AuthDocumentPath)).
+			Type(authDocumentType).
+			Build())
 	}
 
 	if s.EnableSearch {
@@ -860,7 +1211,7 @@ func (s OPDS) makeFeed(catalog *Catalog, req *http.Request) opds.Feed {
 	}
 
 	if catalog.Cover != "" {
-		coverHref := s.joinURL((&url.URL{Path: catalog.Cover}).String())
+		coverHref := s.signedURL((&url.URL{Path: catalog.Cover}).String())
 		feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.
 			Rel("http://opds-spec.org/image").
 			Href(coverHref).
@@ -873,6 +1224,20 @@ func (s OPDS) makeFeed(catalog *Catalog, req *http.Request) opds.Feed {
 			Build())
 	}
 
+	if s.EnableZipDownload && !strings.HasPrefix(catalog.ID, "search:") {
+		recursive := catalog.Type == pathTypeDirOfDirs
+		title := "Download folder as ZIP"
+		if recursive {
+			title = "Download all as ZIP"
+		}
+		feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.
+			Rel("http://opds-spec.org/acquisition").
+			Href(s.joinURL(zipURL(req.URL.Path, recursive))).
+			Type(zipType).
+			Title(title).
+			Build())
+	}
+
 	if !s.NoPagination && catalog.Total > catalog.PageSize {
 		totalPages := (catalog.Total + catalog.PageSize - 1) / catalog.PageSize
 		basePath := req.URL.Path
@@ -915,37 +1280,44 @@ func (s OPDS) makeFeed(catalog *Catalog, req *http.Request) opds.Feed {
 			Build())
 	}
 
-	if catalog.Total > 1 {
-		sortOptions := []struct{ label, value string }{
-			{"Name", "name"},
-			{"Date", "date"},
-			{"Size", "size"},
-		}
-		basePath := req.URL.Path
-		query := req.URL.Query()
-		for _, opt := range sortOptions {
-			facetQuery := cloneURLValues(query)
-			facetQuery.Set("sort", opt.value)
-			facetURL := basePath + "?" + facetQuery.Encode()
-			facetBuilder := opds.LinkBuilder.
-				Rel("http://opds-spec.org/facet").
-				Href(s.joinURL(facetURL)).
-				Title(opt.label).
-				Type(feedType).
-				FacetGroup("Sort By")
-			if opt.value == s.SortBy || (s.SortBy == "" && opt.value == "name") {
-				facetBuilder = facetBuilder.ActiveFacet("true")
+	// a static export has a single listing of each folder
+	if catalog.Total > 1 && s.static == nil {
+		addFacets := func(param, group, active string, options []facetOption) {
+			for _, opt := range options {
+				facetQuery := cloneURLValues(req.URL.Query())
+				facetQuery.Set(param, opt.value)
+				facetURL := req.URL.Path + "?" + facetQuery.Encode()
+				facetBuilder := opds.LinkBuilder.
+					Rel("http://opds-spec.org/facet").
+					Href(s.joinURL(facetURL)).
+					Title(opt.label).
+					Type(feedType).
+					FacetGroup(group)
+				if opt.value == active {
+					facetBuilder = facetBuilder.ActiveFacet("true")
+				}
+				feedBuilder = feedBuilder.AddLink(facetBuilder.Build())
 			}
-			feedBuilder = feedBuilder.AddLink(facetBuilder.Build())
 		}
+		addFacets("sort", "Sort By", cmp.Or(s.SortBy, "name"), s.sortFacets())
+		addFacets("order", "Order", s.sortOrder(), orderOptions)
 	}
 
-	for _, entry := range catalog.Entries {
-		title := entry.Name
-		if entry.Title != "" {
-			title = entry.Title
+	for _, facet := range catalog.Facets {
+		facetBuilder := opds.LinkBuilder.
+			Rel("http://opds-spec.org/facet").
+			Href(s.joinURL(facet.url(req))).
+			Title(facet.Label).
+			Type(feedType).
+			FacetGroup(facet.Group).
+			Count(facet.Count)
+		if facet.Active {
+			facetBuilder = facetBuilder.ActiveFacet("true")
 		}
+		feedBuilder = feedBuilder.AddLink(facetBuilder.Build())
+	}
 
+	for _, entry := range catalog.Entries {
 		var entryPath string
 		if strings.HasPrefix(catalog.ID, "search:") {
 			entryPath = "/" + entry.Name
@@ -954,64 +1326,111 @@ func (s OPDS) makeFeed(catalog *Catalog, req *http.Request) opds.Feed {
 		}
 
 		href := s.joinURL((&url.URL{Path: entryPath}).String())
+		if entry.Query != "" {
+			href = s.joinURL((&url.URL{Path: req.URL.Path, RawQuery: entry.Query}).String())
+		}
+		if entry.Type == pathTypeFile {
+			href = s.signedURL((&url.URL{Path: entryPath}).String())
+		}
 
-		entryBuilder := opds.EntryBuilder.
-			ID(req.URL.Path + entry.Name).
-			Title(title).
-			Published(entry.ModTime.UTC()).
-			Updated(entry.ModTime.UTC()).
-			AddLink(opds.LinkBuilder.
-				Rel(getRel(entry.Name, entry.Type)).
-				Title(entry.Name).
-				Href(href).
-				Type(s.getType(entry.Name, entry.Type)).
+		e := s.makeEntry(entry, s.mountPath(req.URL.Path)+entry.Name, entryPath, href)
+		if entry.Type == pathTypeFile {
+			// the complete entry of the book has all of its metadata and formats
+			e.Link = append(e.Link, opds.LinkBuilder.
+				Rel("alternate").
+				Href(s.joinURL(entryURL(entryPath))).
+				Type(entryType).
 				Build())
-
-		if entry.Author != "" {
-			entryBuilder = entryBuilder.Author(&opds.Person{Name: entry.Author})
 		}
+		feedBuilder = feedBuilder.AddEntry(e)
+	}
+	feed := feedBuilder.Build()
+	if len(catalog.Facets) > 0 {
+		feed.Thr = "http://purl.org/syndication/thread/1.0"
+	}
+	return feed
+}
 
-		if s.ExtractMetadata && entry.Description != "" {
-			text := opds.TextBuilder.Body(entry.Description).Build()
-			entryBuilder = entryBuilder.Summary(&text)
-		}
+// makeEntry builds the partial entry of a catalog, entryPath is its path in
+// the URL and href where its link goes
+func (s OPDS) makeEntry(entry CatalogEntry, id, entryPath, href string) opds.Entry {
+	title := entry.Name
+	if entry.Title != "" {
+		title = entry.Title
+	}
+
+	entryBuilder := opds.EntryBuilder.
+		ID(id).
+		Title(title).
+		Published(entry.ModTime.UTC()).
+		Updated(entry.ModTime.UTC()).
+		AddLink(opds.LinkBuilder.
+			Rel(getRel(entry.Name, entry.Type)).
+			Title(entry.Name).
+			Href(href).
+			Type(s.getType(entry.Name, entry.Type)).
+			Build())
 
-		if s.ExtractMetadata && entry.Series != "" {
-			entryBuilder = entryBuilder.Series(entry.Series)
-		}
-		if s.ExtractMetadata && entry.SeriesIndex != "" {
-			entryBuilder = entryBuilder.SeriesPosition(entry.SeriesIndex)
-		}
+	if entry.Author != "" {
+		entryBuilder = entryBuilder.Author(&opds.Person{Name: entry.Author})
+	}
 
-		if s.ExtractMetadata && entry.CoverPath != "" && entry.Type == pathTypeFile {
-			coverURL := s.joinURL("/cover?file=" + url.QueryEscape(entryPath))
-			ext := strings.ToLower(filepath.Ext(entry.CoverPath))
-			contentType := mime.TypeByExtension(ext)
-			if contentType == "" {
-				contentType = "image/jpeg"
-			}
-			entryBuilder = entryBuilder.AddLink(opds.LinkBuilder.
-				Rel("http://opds-spec.org/image").
-				Href(coverURL).
-				Type(contentType).
-				Build())
-			entryBuilder = entryBuilder.AddLink(opds.LinkBuilder.
-				Rel("http://opds-spec.org/image/thumbnail").
-				Href(coverURL).
-				Type(contentType).
-				Build())
-		}
+	// the descriptions of the folders come from their sidecar file
+	if entry.Description != "" && (s.ExtractMetadata || entry.Type != pathTypeFile) {
+		text := opds.TextBuilder.Body(entry.Description).Build()
+		entryBuilder = entryBuilder.Summary(&text)
+	}
 
-		for _, subject := range entry.Subjects {
-			entryBuilder = entryBuilder.AddCategory(opds.Category{
-				Term:  subject,
-				Label: subject,
-			})
+	if s.ExtractMetadata && entry.Series != "" {
+		entryBuilder = entryBuilder.Series(entry.Series)
+	}
+	if s.ExtractMetadata && entry.SeriesIndex != "" {
+		entryBuilder = entryBuilder.SeriesPosition(entry.SeriesIndex)
+	}
+
+	if s.ExtractMetadata && entry.CoverPath != "" && entry.Type == pathTypeFile {
+		coverURL := s.signedURL("/cover?file=" + url.QueryEscape(entryPath))
+		ext := strings.ToLower(filepath.Ext(entry.CoverPath))
+		contentType := mime.TypeByExtension(ext)
+		if contentType == "" {
+			contentType = "image/jpeg"
 		}
+		entryBuilder = entryBuilder.AddLink(opds.LinkBuilder.
+			Rel("http://opds-spec.org/image").
+			Href(coverURL).
+			Type(contentType).
+			Build())
+		entryBuilder = entryBuilder.AddLink(opds.LinkBuilder.
+			Rel("http://opds-spec.org/image/thumbnail").
+			Href(coverURL).
+			Type(contentType).
+			Build())
+	}
+
+	if entry.CoverPath != "" && entry.Type != pathTypeFile {
+		coverPath := path.Join(entryPath, entry.CoverPath)
+		coverURL := s.signedURL((&url.URL{Path: coverPath}).String())
+		contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(coverPath)))
+		entryBuilder = entryBuilder.AddLink(opds.LinkBuilder.
+			Rel("http://opds-spec.org/image").
+			Href(coverURL).
+			Type(contentType).
+			Build())
+		entryBuilder = entryBuilder.AddLink(opds.LinkBuilder.
+			Rel("http://opds-spec.org/image/thumbnail").
+			Href(coverURL).
+			Type(contentType).
+			Build())
+	}
 
-		feedBuilder = feedBuilder.AddEntry(entryBuilder.Build())
+	for _, subject := range entry.Subjects {
+		entryBuilder = entryBuilder.AddCategory(opds.Category{
+			Term:  subject,
+			Label: subject,
+		})
 	}
-	return feedBuilder.Build()
+
+	return entryBuilder.Build()
 }
 
 func buildPageURL(basePath string, query url.Values, page int) string {
@@ -1029,19 +1448,22 @@ func fileShouldBeIgnored(filename string, hideCalibreFiles, hideDotFiles bool) b
 		return ignoreFile
 	}
 
-	if hideCalibreFiles &&
-		(strings.Contains(filename, ".opf") ||
-			strings.Contains(filename, "cover.") ||
-			strings.Contains(filename, "metadata.db") ||
-			strings.Contains(filename, "metadata_db_prefs_backup.json") ||
-			strings.Contains(filename, ".caltrash") ||
-			strings.Contains(filename, ".calnotes")) {
+	if hideCalibreFiles && isCalibreFile(filename) {
 		return ignoreFile
 	}
 
 	return false
 }
 
+// isCalibreFile reports whether filename is one of the files calibre stores next to the books
+func isCalibreFile(filename string) bool {
+	switch filename {
+	case "cover.jpg", "cover.jpeg", "cover.png", "metadata_db_prefs_backup.json", ".caltrash", ".calnotes":
+		return true
+	}
+	return path.Ext(filename) == ".opf" || strings.HasPrefix(filename, "metadata.db")
+}
+
 func getRel(name string, pathType int) string {
 	if pathType == pathTypeDirOfFiles || pathType == pathTypeDirOfDirs {
 		return "subsection"
@@ -1075,28 +1497,10 @@ func (s OPDS) getType(name string, pathType int) string {
 	}
 }
 
-func getPathType(dirpath string) int {
-	fi, err := os.Stat(dirpath)
-	if err != nil {
-		slog.Error("getPathType os.Stat error", "error", err)
-		return pathTypeFile
-	}
-
-	if isFile(fi) {
-		return pathTypeFile
-	}
-
-	dirEntries, err := os.ReadDir(dirpath)
-	if err != nil {
-		slog.Error("getPathType: readDir error", "error", err)
-	}
-
-	for _, entry := range dirEntries {
-		if isFile(entry) && !strings.HasPrefix(entry.Name(), hiddenFilePrefix) {
-			return pathTypeDirOfFiles
-		}
-	}
-	return pathTypeDirOfDirs
+// getPathType classifies the storage name, rules are the ones of the folder that holds it
+func getPathType(fsys fs.FS, name string, rules ignoreRules) int {
+	c := folderClassifier{name: name, rules: rules}
+	return c.classify(fsys)
 }
 
 func timeNowFunc() func() time.Time {
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/dubyte/dir2opds/internal/service"
@@ -97,7 +98,7 @@ func TestScan(t *testing.T) {
 	s := service.OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, HideDotFiles: true}
 
 	t.Run("Scan root (dir of dirs)", func(t *testing.T) {
-		catalog, err := s.Scan("testdata", "/", 1)
+		catalog, err := s.Scan(".", "/", 1)
 		require.NoError(t, err)
 		assert.Equal(t, "/", catalog.ID)
 		// testdata has 3 folders: emptyFolder, mybook, new folder
@@ -105,7 +106,7 @@ func TestScan(t *testing.T) {
 	})
 
 	t.Run("Scan mybook (dir of files)", func(t *testing.T) {
-		catalog, err := s.Scan("testdata/mybook", "/mybook", 1)
+		catalog, err := s.Scan("mybook", "/mybook", 1)
 		require.NoError(t, err)
 		assert.Equal(t, "/mybook", catalog.ID)
 		// mybook has 6 files but mybook.opf should be ignored
@@ -116,7 +117,7 @@ func TestScan(t *testing.T) {
 	})
 
 	t.Run("Scan empty folder", func(t *testing.T) {
-		catalog, err := s.Scan("testdata/emptyFolder", "/emptyFolder", 1)
+		catalog, err := s.Scan("emptyFolder", "/emptyFolder", 1)
 		require.NoError(t, err)
 		assert.Empty(t, catalog.Entries)
 	})
@@ -273,6 +274,8 @@ var feed = `<?xml version="1.0" encoding="UTF-8"?>
       <link rel="http://opds-spec.org/facet" href="/?sort=name" type="application/atom+xml;profile=opds-catalog;kind=navigation" title="Name" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Sort By" catalog:activeFacet="true"></link>
       <link rel="http://opds-spec.org/facet" href="/?sort=date" type="application/atom+xml;profile=opds-catalog;kind=navigation" title="Date" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Sort By"></link>
       <link rel="http://opds-spec.org/facet" href="/?sort=size" type="application/atom+xml;profile=opds-catalog;kind=navigation" title="Size" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Sort By"></link>
+      <link rel="http://opds-spec.org/facet" href="/?order=asc" type="application/atom+xml;profile=opds-catalog;kind=navigation" title="Ascending" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Order" catalog:activeFacet="true"></link>
+      <link rel="http://opds-spec.org/facet" href="/?order=desc" type="application/atom+xml;profile=opds-catalog;kind=navigation" title="Descending" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Order"></link>
       <updated>2020-05-25T00:00:00+00:00</updated>
       <entry>
           <title>emptyFolder</title>
@@ -298,7 +301,7 @@ var feed = `<?xml version="1.0" encoding="UTF-8"?>
   </feed>`
 
 var acquisitionFeed = `<?xml version="1.0" encoding="UTF-8"?>
-  <feed xmlns="http://www.w3.org/2005/Atom" xmlns:opds="http://opds-spec.org/2010/catalog" xmlns:dc="http://purl.org/dc/terms/">
+  <feed xmlns="http://www.w3.org/2005/Atom" xmlns:opds="http://opds-spec.org/2010/catalog" xmlns:thr="http://purl.org/syndication/thread/1.0" xmlns:dc="http://purl.org/dc/terms/">
       <title>Catalog in /mybook</title>
       <id>/mybook</id>
       <link rel="start" href="/" type="application/atom+xml;profile=opds-catalog;kind=navigation"></link>
@@ -307,11 +310,18 @@ var acquisitionFeed = `<?xml version="1.0" encoding="UTF-8"?>
       <link rel="http://opds-spec.org/facet" href="/mybook?sort=name" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="Name" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Sort By" catalog:activeFacet="true"></link>
       <link rel="http://opds-spec.org/facet" href="/mybook?sort=date" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="Date" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Sort By"></link>
       <link rel="http://opds-spec.org/facet" href="/mybook?sort=size" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="Size" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Sort By"></link>
+      <link rel="http://opds-spec.org/facet" href="/mybook?order=asc" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="Ascending" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Order" catalog:activeFacet="true"></link>
+      <link rel="http://opds-spec.org/facet" href="/mybook?order=desc" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="Descending" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Order"></link>
+      <link rel="http://opds-spec.org/facet" href="/mybook" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="All" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Format" catalog:activeFacet="true" thr:count="5"></link>
+      <link rel="http://opds-spec.org/facet" href="/mybook?format=epub" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="EPUB" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Format" thr:count="2"></link>
+      <link rel="http://opds-spec.org/facet" href="/mybook?format=pdf" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="PDF" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Format" thr:count="1"></link>
+      <link rel="http://opds-spec.org/facet" href="/mybook?format=txt" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="TXT" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Format" thr:count="2"></link>
       <updated>2020-05-25T00:00:00+00:00</updated>
       <entry>
           <title>mybook copy.epub</title>
           <id>/mybookmybook copy.epub</id>
           <link rel="http://opds-spec.org/acquisition/open-access" href="/mybook/mybook%20copy.epub" type="application/epub+zip" title="mybook copy.epub"></link>
+          <link rel="alternate" href="/_entry?file=%2Fmybook%2Fmybook+copy.epub" type="application/atom+xml;type=entry;profile=opds-catalog"></link>
           <published>2020-05-25T00:00:00+00:00</published>
           <updated>2020-05-25T00:00:00+00:00</updated>
       </entry>
@@ -319,6 +329,7 @@ var acquisitionFeed = `<?xml version="1.0" encoding="UTF-8"?>
           <title>mybook copy.txt</title>
           <id>/mybookmybook copy.txt</id>
           <link rel="http://opds-spec.org/acquisition/open-access" href="/mybook/mybook%20copy.txt" type="text/plain; charset=utf-8" title="mybook copy.txt"></link>
+          <link rel="alternate" href="/_entry?file=%2Fmybook%2Fmybook+copy.txt" type="application/atom+xml;type=entry;profile=opds-catalog"></link>
           <published>2020-05-25T00:00:00+00:00</published>
           <updated>2020-05-25T00:00:00+00:00</updated>
       </entry>
@@ -326,6 +337,7 @@ var acquisitionFeed = `<?xml version="1.0" encoding="UTF-8"?>
           <title>mybook.epub</title>
           <id>/mybookmybook.epub</id>
           <link rel="http://opds-spec.org/acquisition/open-access" href="/mybook/mybook.epub" type="application/epub+zip" title="mybook.epub"></link>
+          <link rel="alternate" href="/_entry?file=%2Fmybook%2Fmybook.epub" type="application/atom+xml;type=entry;profile=opds-catalog"></link>
           <published>2020-05-25T00:00:00+00:00</published>
           <updated>2020-05-25T00:00:00+00:00</updated>
       </entry>
@@ -333,6 +345,7 @@ var acquisitionFeed = `<?xml version="1.0" encoding="UTF-8"?>
           <title>mybook.pdf</title>
           <id>/mybookmybook.pdf</id>
           <link rel="http://opds-spec.org/acquisition/open-access" href="/mybook/mybook.pdf" type="application/pdf" title="mybook.pdf"></link>
+          <link rel="alternate" href="/_entry?file=%2Fmybook%2Fmybook.pdf" type="application/atom+xml;type=entry;profile=opds-catalog"></link>
           <published>2020-05-25T00:00:00+00:00</published>
           <updated>2020-05-25T00:00:00+00:00</updated>
       </entry>
@@ -340,6 +353,7 @@ var acquisitionFeed = `<?xml version="1.0" encoding="UTF-8"?>
           <title>mybook.txt</title>
           <id>/mybookmybook.txt</id>
           <link rel="http://opds-spec.org/acquisition/open-access" href="/mybook/mybook.txt" type="text/plain; charset=utf-8" title="mybook.txt"></link>
+          <link rel="alternate" href="/_entry?file=%2Fmybook%2Fmybook.txt" type="application/atom+xml;type=entry;profile=opds-catalog"></link>
           <published>2020-05-25T00:00:00+00:00</published>
           <updated>2020-05-25T00:00:00+00:00</updated>
       </entry>
@@ -458,3 +472,55 @@ func TestCrawlableFeed(t *testing.T) {
 		assert.Contains(t, string(body), "<title>new folder</title>")
 	})
 }
+
+func TestMapFSStorage(t *testing.T) {
+	modTime := time.Date(2020, 5, 25, 0, 0, 0, 0, time.UTC)
+	s := service.OPDS{
+		Storage: fstest.MapFS{
+			"Tolkien/The Hobbit.txt":  {Data: []byte("In a hole in the ground"), ModTime: modTime},
+			"Tolkien/.hidden.txt":     {Data: []byte("hidden"), ModTime: modTime},
+			"Tolkien/metadata.opf":    {Data: []byte("<package/>"), ModTime: modTime},
+			"Austen/Emma.txt":         {Data: []byte("Emma Woodhouse"), ModTime: modTime},
+			"Austen/Persuasion.txt":   {Data: []byte("Sir Walter Elliot"), ModTime: modTime},
+			"Austen/Sanditon/one.txt": {Data: []byte("A gentleman"), ModTime: modTime},
+		},
+		HideCalibreFiles: true,
+		HideDotFiles:     true,
+	}
+
+	t.Run("Scan", func(t *testing.T) {
+		catalog, err := s.Scan(".", "/", 1)
+		require.NoError(t, err)
+		require.Len(t, catalog.Entries, 2)
+		assert.Equal(t, "Austen", catalog.Entries[0].Name)
+		assert.Equal(t, "Tolkien", catalog.Entries[1].Name)
+
+		catalog, err = s.Scan("Tolkien", "/Tolkien", 1)
+		require.NoError(t, err)
+		require.Len(t, catalog.Entries, 1)
+		assert.Equal(t, "The Hobbit.txt", catalog.Entries[0].Name)
+	})
+
+	t.Run("serving a file", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/Austen/Emma.txt", nil)
+		require.NoError(t, s.Handler(w, req))
+		assert.Equal(t, "Emma Woodhouse", w.Body.String())
+	})
+
+	t.Run("search", func(t *testing.T) {
+		s := s
+		s.EnableSearch = true
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/search?q=one", nil)
+		require.NoError(t, s.SearchHandler(w, req))
+		assert.Contains(t, w.Body.String(), `href="/Austen/Sanditon/one.txt"`)
+	})
+
+	t.Run("path traversal", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/../Austen/Emma.txt", nil)
+		require.NoError(t, s.Handler(w, req))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	expiresParam   = "expires"
+	signatureParam = "sig"
+	signerParam    = "user"
+
+	minSigningKeySize = 16
+)
+
+// clock is the time the URLs are signed and verified at. Unlike TimeNow,
+// which is the start of the process, it moves, so the links expire.
+var clock = time.Now
+
+// URLSigner signs the URLs of the books and covers in the feeds so they can be
+// downloaded without credentials, by readers that can't send them or by a
+// friend the link is shared with, until they expire.
+type URLSigner struct {
+	Key []byte
+	TTL time.Duration
+}
+
+// LoadSigningKey reads the secret key of a URLSigner from a file, e.g. made with
+// openssl rand -hex 32
+func LoadSigningKey(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < minSigningKeySize {
+		return nil, fmt.Errorf("%s: the key must have at least %d characters", name, minSigningKeySize)
+	}
+	return key, nil
+}
+
+// expires returns when the URLs signed now expire. It is rounded up to the hour
+// so the feeds only change once an hour and can still be cached.
+func (u *URLSigner) expires() time.Time {
+	return u.window().Add(time.Hour + u.TTL)
+}
+
+// window returns when the URLs signed now started to be signed with the same expiry
+func (u *URLSigner) window() time.Time {
+	return clock().UTC().Truncate(time.Hour)
+}
+
+// Sign adds the expiry, the user and the signature to the query of href, a path
+// from the root of the server with an optional query.
+func (u *URLSigner) Sign(href, user string) string {
+	target, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+
+	query := target.Query()
+	query.Set(expiresParam, strconv.FormatInt(u.expires().Unix(), 10))
+	if user != "" {
+		query.Set(signerParam, user)
+	}
+	query.Set(signatureParam, u.signature(target.Path, query))
+	target.RawQuery = query.Encode()
+	return target.String()
+}
+
+// Verify checks the signature and expiry of target and returns the user it was signed for
+func (u *URLSigner) Verify(target *url.URL) (string, bool) {
+	query := target.Query()
+	sig := query.Get(signatureParam)
+	if sig == "" {
+		return "", false
+	}
+
+	expires, err := strconv.ParseInt(query.Get(expiresParam), 10, 64)
+	if err != nil || !clock().Before(time.Unix(expires, 0)) {
+		return "", false
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(u.signature(target.Path, query))) {
+		return "", false
+	}
+	return query.Get(signerParam), true
+}
+
+// signature is the HMAC of the path and the query without the signature
+func (u *URLSigner) signature(p string, query url.Values) string {
+	unsigned := cloneURLValues(query)
+	unsigned.Del(signatureParam)
+
+	mac := hmac.New(sha256.New, u.Key)
+	mac.Write([]byte(p + "?" + unsigned.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedURL returns the absolute URL of p, signed for the user of the request
+// when the OPDS has a Signer.
+func (s OPDS) signedURL(p string) string {
+	if s.Signer == nil {
+		return s.joinURL(p)
+	}
+	return joinBaseURL(s.BaseURL, s.Signer.Sign(s.mountPath(p), s.User))
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixClock stops the clock of the signatures at now
+func fixClock(t *testing.T, now time.Time) {
+	t.Helper()
+	nowFn := clock
+	t.Cleanup(func() { clock = nowFn })
+	clock = func() time.Time { return now }
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(p, []byte("0123456789abcdef0123456789abcdef\n"), 0o600))
+	key, err := LoadSigningKey(p)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("0123456789abcdef0123456789abcdef"), key)
+
+	require.NoError(t, os.WriteFile(p, []byte("short\n"), 0o600))
+	_, err = LoadSigningKey(p)
+	assert.ErrorContains(t, err, "at least 16 characters")
+}
+
+func TestURLSigner(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 30, 0, 0, time.UTC)
+	fixClock(t, now)
+	signer := &URLSigner{Key: []byte("0123456789abcdef"), TTL: 48 * time.Hour}
+
+	signed := signer.Sign("/cover?file=%2Fmybook%2Fmybook.epub", "alice")
+	target, err := url.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "/cover", target.Path)
+	assert.Equal(t, "/mybook/mybook.epub", target.Query().Get("file"))
+	assert.Equal(t, "alice", target.Query().Get("user"))
+	// rounded up to the hour
+	assert.Equal(t, "1792587600", target.Query().Get("expires"))
+
+	user, ok := signer.Verify(target)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", user)
+
+	t.Run("tampered", func(t *testing.T) {
+		for param, value := range map[string]string{"file": "/private/taxes.pdf", "user": "bob", "expires": "1892587600"} {
+			tampered := *target
+			query := tampered.Query()
+			query.Set(param, value)
+			tampered.RawQuery = query.Encode()
+			_, ok := signer.Verify(&tampered)
+			assert.False(t, ok, param)
+		}
+
+		other := *target
+		other.Path = "/mybook/mybook.pdf"
+		_, ok := signer.Verify(&other)
+		assert.False(t, ok)
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		_, ok := (&URLSigner{Key: []byte("fedcba9876543210")}).Verify(target)
+		assert.False(t, ok)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		fixClock(t, now.Add(49*time.Hour+30*time.Minute))
+		_, ok := signer.Verify(target)
+		assert.False(t, ok)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		_, ok := signer.Verify(&url.URL{Path: "/mybook/mybook.epub"})
+		assert.False(t, ok)
+	})
+
+	t.Run("expired while the server runs", func(t *testing.T) {
+		// TimeNow stays at the start of the process
+		timeNow := TimeNow
+		t.Cleanup(func() { TimeNow = timeNow })
+		TimeNow = func() time.Time { return now }
+
+		fixClock(t, now.Add(signer.TTL+2*time.Hour))
+		_, ok := signer.Verify(target)
+		assert.False(t, ok)
+	})
+}
+
+func TestSignedLinks(t *testing.T) {
+	fixClock(t, time.Date(2026, 10, 19, 12, 30, 0, 0, time.UTC))
+	signer := &URLSigner{Key: []byte("0123456789abcdef"), TTL: 48 * time.Hour}
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"fiction/dune.txt":  {Data: []byte("Arrakis")},
+			"fiction/sub/a.txt": {Data: []byte("a")},
+		},
+		EnableAuth: true,
+		Signer:     signer,
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := s
+		s.User = UserFromContext(r.Context())
+		_ = s.Handler(w, r)
+	})
+	h := BasicAuth{Users: testUsers(t), Public: []string{"/health"}, Signer: signer}.Middleware(next)
+
+	req := httptest.NewRequest(http.MethodGet, "/fiction/", nil)
+	req.SetBasicAuth("alice", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `href="/fiction/sub"`, "folders are not signed")
+	hrefs := regexp.MustCompile(`href="(/fiction/dune.txt\?[^"]+)"`).FindStringSubmatch(body)
+	require.Len(t, hrefs, 2, body)
+	href := strings.ReplaceAll(hrefs[1], "&amp;", "&")
+	assert.Contains(t, href, "user=alice")
+
+	t.Run("download without credentials", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, href, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		content, _ := io.ReadAll(rec.Body)
+		assert.Equal(t, "Arrakis", string(content))
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, href+"x", nil))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("etag changes with the expiry", func(t *testing.T) {
+		s := s
+		s.EnableCache = true
+		s.User = "alice"
+		etagAt := func(now time.Time) string {
+			fixClock(t, now)
+			rec := httptest.NewRecorder()
+			require.NoError(t, s.Handler(rec, httptest.NewRequest(http.MethodGet, "/fiction/", nil)))
+			return rec.Header().Get("ETag")
+		}
+		first := etagAt(time.Date(2026, 10, 19, 12, 10, 0, 0, time.UTC))
+		assert.Equal(t, first, etagAt(time.Date(2026, 10, 19, 12, 50, 0, 0, time.UTC)))
+		assert.NotEqual(t, first, etagAt(time.Date(2026, 10, 19, 13, 10, 0, 0, time.UTC)))
+	})
+
+	t.Run("other method", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, href, nil))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
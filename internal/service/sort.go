@@ -0,0 +1,221 @@
+package service
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// facetOption is a value of a query parameter with the title of its facet
+type facetOption struct{ label, value string }
+
+// sortOptions are the ways of sorting the entries
+var sortOptions = []facetOption{
+	{"Name", "name"},
+	{"Title", "title"},
+	{"Author", "author"},
+	{"Series", "series"},
+	{"Date", "date"},
+	{"Size", "size"},
+}
+
+// orderOptions are the directions of the sort
+var orderOptions = []facetOption{
+	{"Ascending", "asc"},
+	{"Descending", "desc"},
+}
+
+// SortKeys returns the known ways of sorting the entries
+func SortKeys() []string {
+	keys := make([]string, 0, len(sortOptions))
+	for _, opt := range sortOptions {
+		keys = append(keys, opt.value)
+	}
+	return keys
+}
+
+// ValidSort reports whether sortBy is a known way of sorting the entries
+func ValidSort(sortBy string) bool {
+	return slices.Contains(SortKeys(), sortBy)
+}
+
+// SortNeedsMetadata reports whether sortBy sorts by the metadata of the books,
+// which needs ExtractMetadata
+func SortNeedsMetadata(sortBy string) bool {
+	return sortBy == "title" || sortBy == "author" || sortBy == "series"
+}
+
+// sortFacets returns the ways of sorting the entries offered by s, the ones
+// by the metadata only when it is extracted
+func (s OPDS) sortFacets() []facetOption {
+	if s.ExtractMetadata {
+		return sortOptions
+	}
+	return slices.DeleteFunc(slices.Clone(sortOptions), func(opt facetOption) bool {
+		return SortNeedsMetadata(opt.value)
+	})
+}
+
+// ValidSortOrder reports whether order is asc or desc
+func ValidSortOrder(order string) bool {
+	return order == "asc" || order == "desc"
+}
+
+// sortOrder returns the direction of the sort. When SortOrder is not set the
+// newest and the biggest entries go first, and the others from A to Z.
+func (s OPDS) sortOrder() string {
+	if s.SortOrder != "" {
+		return s.SortOrder
+	}
+	switch s.SortBy {
+	case "date", "size":
+		return "desc"
+	}
+	return "asc"
+}
+
+// ValidSortLocale reports whether locale is a BCP 47 language tag, empty is the default Unicode order
+func ValidSortLocale(locale string) bool {
+	if locale == "" {
+		return true
+	}
+	_, err := language.Parse(locale)
+	return err == nil
+}
+
+// sorter compares texts like people expect: numbers by their value, so Vol 2
+// goes before Vol 10, accented letters next to their base letter, following
+// the rules of a language, and optionally without the leading articles.
+type sorter struct {
+	collator *collate.Collator
+	articles []string
+}
+
+// newSorter returns a sorter for the locale and articles of s, a collator must
+// not be shared between goroutines so each sort makes its own.
+func (s OPDS) newSorter() *sorter {
+	tag := language.Und
+	if s.SortLocale != "" {
+		tag = language.Make(s.SortLocale)
+	}
+	return &sorter{
+		collator: collate.New(tag, collate.Numeric),
+		articles: s.SortArticles,
+	}
+}
+
+// key removes the leading article of text. An article that ends in an
+// apostrophe, like L', needs no space after it.
+func (c *sorter) key(text string) string {
+	for _, article := range c.articles {
+		sep := " "
+		if strings.HasSuffix(article, "'") {
+			sep = ""
+		}
+		prefix := article + sep
+		if len(text) > len(prefix) && strings.EqualFold(text[:len(prefix)], prefix) {
+			return text[len(prefix):]
+		}
+	}
+	return text
+}
+
+func (c *sorter) compare(a, b string) int {
+	return c.collator.CompareString(c.key(a), c.key(b))
+}
+
+// missingLast puts the empty values after the others, whatever the order
+func missingLast(a, b string) int {
+	if (a == "") != (b == "") {
+		if a == "" {
+			return 1
+		}
+		return -1
+	}
+	return 0
+}
+
+// compareSeriesIndex compares the positions in a series, as numbers when they are
+func compareSeriesIndex(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return cmp.Compare(x, y)
+	}
+	return strings.Compare(a, b)
+}
+
+// title is the title of the entry, its name when it has none
+func (e CatalogEntry) title() string {
+	if e.Title != "" {
+		return e.Title
+	}
+	return e.Name
+}
+
+// sortsByTitle reports whether the order of the entries depends on their titles
+func (s OPDS) sortsByTitle() bool {
+	switch s.SortBy {
+	case "title", "author", "series":
+		return true
+	}
+	return false
+}
+
+// sortEntries sorts the entries by s.SortBy in the direction of s.sortOrder,
+// the ties are sorted by name
+func (s OPDS) sortEntries(entries []CatalogEntry) {
+	c := s.newSorter()
+	byName := func(a, b CatalogEntry) int {
+		return c.compare(a.Name, b.Name)
+	}
+	dir := 1
+	if s.sortOrder() == "desc" {
+		dir = -1
+	}
+
+	var compare func(a, b CatalogEntry) int
+	switch s.SortBy {
+	case "date":
+		compare = func(a, b CatalogEntry) int {
+			return dir * a.ModTime.Compare(b.ModTime)
+		}
+	case "size":
+		compare = func(a, b CatalogEntry) int {
+			return dir * cmp.Compare(a.Size, b.Size)
+		}
+	case "title":
+		compare = func(a, b CatalogEntry) int {
+			return dir * c.compare(a.title(), b.title())
+		}
+	case "author":
+		compare = func(a, b CatalogEntry) int {
+			return cmp.Or(
+				missingLast(a.Author, b.Author),
+				dir*c.compare(a.Author, b.Author),
+				dir*c.compare(a.title(), b.title()),
+			)
+		}
+	case "series":
+		compare = func(a, b CatalogEntry) int {
+			return cmp.Or(
+				missingLast(a.Series, b.Series),
+				dir*c.compare(a.Series, b.Series),
+				dir*compareSeriesIndex(a.SeriesIndex, b.SeriesIndex),
+				dir*c.compare(a.title(), b.title()),
+			)
+		}
+	default: // name
+		compare = func(a, b CatalogEntry) int {
+			return dir * byName(a, b)
+		}
+	}
+
+	slices.SortStableFunc(entries, func(a, b CatalogEntry) int {
+		return cmp.Or(compare(a, b), byName(a, b))
+	})
+}
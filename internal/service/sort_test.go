@@ -0,0 +1,163 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNaturalSort(t *testing.T) {
+	names := func(entries []CatalogEntry) []string {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+	sorted := func(s OPDS, entries ...CatalogEntry) []string {
+		s.sortEntries(entries)
+		return names(entries)
+	}
+	named := func(names ...string) []CatalogEntry {
+		var entries []CatalogEntry
+		for _, name := range names {
+			entries = append(entries, CatalogEntry{Name: name})
+		}
+		return entries
+	}
+
+	t.Run("numbers", func(t *testing.T) {
+		assert.Equal(t, []string{"Vol 2", "Vol 9", "Vol 10", "Vol 100"}, sorted(OPDS{}, named("Vol 10", "Vol 2", "Vol 100", "Vol 9")...))
+	})
+
+	t.Run("case and accents", func(t *testing.T) {
+		assert.Equal(t, []string{"apple", "Banana", "Émile", "zebra"}, sorted(OPDS{}, named("zebra", "Émile", "Banana", "apple")...))
+	})
+
+	t.Run("locale", func(t *testing.T) {
+		entries := named("Zorro", "Ödön", "Otto")
+		assert.Equal(t, []string{"Ödön", "Otto", "Zorro"}, sorted(OPDS{}, entries...))
+		// in Swedish Ö is a letter of its own after Z
+		assert.Equal(t, []string{"Otto", "Zorro", "Ödön"}, sorted(OPDS{SortLocale: "sv"}, entries...))
+	})
+
+	t.Run("articles", func(t *testing.T) {
+		s := OPDS{SortArticles: []string{"the", "a", "l'"}}
+		assert.Equal(t, []string{"Anathem", "L'Étranger", "The Hobbit", "Theory", "a Zoo"},
+			sorted(s, named("The Hobbit", "Theory", "Anathem", "a Zoo", "L'Étranger")...))
+	})
+
+	t.Run("title", func(t *testing.T) {
+		s := OPDS{SortBy: "title"}
+		assert.Equal(t, []string{"3.epub", "2.epub", "1.epub"}, sorted(s,
+			CatalogEntry{Name: "1.epub", Title: "Dune"},
+			CatalogEntry{Name: "2.epub", Title: "Children of Dune"},
+			CatalogEntry{Name: "3.epub"},
+		))
+	})
+
+	t.Run("author", func(t *testing.T) {
+		s := OPDS{SortBy: "author"}
+		assert.Equal(t, []string{"b.epub", "a.epub", "c.epub", "d.epub"}, sorted(s,
+			CatalogEntry{Name: "d.epub"},
+			CatalogEntry{Name: "a.epub", Author: "Le Guin", Title: "The Lathe of Heaven"},
+			CatalogEntry{Name: "c.epub", Author: "Pratchett"},
+			CatalogEntry{Name: "b.epub", Author: "Le Guin", Title: "Lavinia"},
+		))
+	})
+
+	t.Run("series", func(t *testing.T) {
+		s := OPDS{SortBy: "series"}
+		assert.Equal(t, []string{"1.epub", "2.epub", "10.epub", "other.epub", "none.epub"}, sorted(s,
+			CatalogEntry{Name: "none.epub"},
+			CatalogEntry{Name: "10.epub", Series: "Discworld", SeriesIndex: "10"},
+			CatalogEntry{Name: "other.epub", Series: "Earthsea", SeriesIndex: "1"},
+			CatalogEntry{Name: "2.epub", Series: "Discworld", SeriesIndex: "2"},
+			CatalogEntry{Name: "1.epub", Series: "Discworld", SeriesIndex: "1.0"},
+		))
+	})
+
+	t.Run("order", func(t *testing.T) {
+		now := time.Now()
+		entries := []CatalogEntry{
+			{Name: "b", ModTime: now.Add(-time.Hour)},
+			{Name: "a", ModTime: now},
+			{Name: "c", ModTime: now.Add(-2 * time.Hour)},
+		}
+		assert.Equal(t, []string{"c", "b", "a"}, sorted(OPDS{SortOrder: "desc"}, entries...))
+		assert.Equal(t, []string{"a", "b", "c"}, sorted(OPDS{SortBy: "date"}, entries...), "newest first")
+		assert.Equal(t, []string{"c", "b", "a"}, sorted(OPDS{SortBy: "date", SortOrder: "asc"}, entries...), "oldest first")
+
+		// the books without an author stay last
+		books := []CatalogEntry{{Name: "1"}, {Name: "2", Author: "Austen"}, {Name: "3", Author: "Zola"}}
+		assert.Equal(t, []string{"3", "2", "1"}, sorted(OPDS{SortBy: "author", SortOrder: "desc"}, books...))
+	})
+
+	assert.True(t, ValidSort("series"))
+	assert.False(t, ValidSort("colour"))
+	assert.True(t, ValidSortLocale(""))
+	assert.True(t, ValidSortLocale("pt-BR"))
+	assert.False(t, ValidSortLocale("not a language"))
+	assert.True(t, ValidSortOrder("desc"))
+	assert.False(t, ValidSortOrder("up"))
+	assert.True(t, SortNeedsMetadata("author"))
+	assert.False(t, SortNeedsMetadata("date"))
+}
+
+func TestSortOrderFacets(t *testing.T) {
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"saga/.dir2opds.yaml": {Data: []byte("sort: date\norder: asc\n")},
+			"saga/Part 1.epub":    {Data: []byte("1"), ModTime: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+			"saga/Part 2.epub":    {Data: []byte("2"), ModTime: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	t.Run("folder order", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/saga", nil)))
+		body := w.Body.String()
+		assert.Less(t, strings.Index(body, "<title>Part 1.epub</title>"), strings.Index(body, "<title>Part 2.epub</title>"), "oldest first")
+		assert.Contains(t, body, `<link rel="http://opds-spec.org/facet" href="/saga?order=asc" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="Ascending" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Order" catalog:activeFacet="true"></link>`)
+		assert.Contains(t, body, `<link rel="http://opds-spec.org/facet" href="/saga?order=desc" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="Descending" xmlns:catalog="http://opds-spec.org/2010/catalog" catalog:facetGroup="Order"></link>`)
+	})
+
+	t.Run("query order", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/saga?order=desc", nil)))
+		body := w.Body.String()
+		assert.Less(t, strings.Index(body, "<title>Part 2.epub</title>"), strings.Index(body, "<title>Part 1.epub</title>"))
+		assert.Contains(t, body, `href="/saga?order=desc&amp;sort=name"`, "the sort facets keep the order")
+	})
+
+	t.Run("metadata facets", func(t *testing.T) {
+		s := s
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/saga", nil)))
+		assert.NotContains(t, w.Body.String(), "sort=title", "without the metadata")
+
+		s.ExtractMetadata = true
+		w = httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/saga", nil)))
+		assert.Contains(t, w.Body.String(), `href="/saga?sort=title"`)
+		assert.Contains(t, w.Body.String(), `href="/saga?sort=series"`)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		s := s
+		s.EnableHTML = true
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/saga?page=2", nil)
+		req.Header.Set("Accept", "text/html")
+		require.NoError(t, s.Handler(w, req))
+		body := w.Body.String()
+		assert.Contains(t, body, `<span class="current">Ascending</span>`)
+		assert.Contains(t, body, `<a href="/saga?order=desc">Descending</a>`)
+	})
+}
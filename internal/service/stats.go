@@ -0,0 +1,150 @@
+package service
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// statsBooks is the number of books of the largest and most recent lists
+const statsBooks = 10
+
+// Stats sums up the books of a library and how complete their metadata is
+type Stats struct {
+	Books int   `json:"books"`
+	Size  int64 `json:"size"`
+	// Formats are by extension, the most used first, and Authors and Series
+	// the ones with the most books first
+	Formats []StatsCount `json:"formats"`
+	Authors []StatsCount `json:"authors"`
+	Series  []StatsCount `json:"series"`
+	Missing StatsMissing `json:"missing"`
+	Largest []StatsBook  `json:"largest"`
+	Recent  []StatsBook  `json:"recent"`
+}
+
+// StatsCount is the number of books with a format, author or series, and their size
+type StatsCount struct {
+	Name  string `json:"name"`
+	Books int    `json:"books"`
+	Size  int64  `json:"size"`
+}
+
+// StatsMissing is the number of books without each piece of metadata
+type StatsMissing struct {
+	Title  int `json:"title"`
+	Author int `json:"author"`
+	Cover  int `json:"cover"`
+}
+
+// StatsBook is a book of the largest or most recent ones, Path is its URL path
+type StatsBook struct {
+	Path    string    `json:"path"`
+	Title   string    `json:"title,omitempty"`
+	Author  string    `json:"author,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// stats sums up books, the ones of the index of the library
+func (s OPDS) stats(books []CatalogEntry) Stats {
+	stats := Stats{Books: len(books)}
+	formats := make(map[string]*StatsCount)
+	authors := make(map[string]*StatsCount)
+	series := make(map[string]*StatsCount)
+	count := func(counts map[string]*StatsCount, name string, size int64) {
+		c, ok := counts[name]
+		if !ok {
+			c = &StatsCount{Name: name}
+			counts[name] = c
+		}
+		c.Books++
+		c.Size += size
+	}
+
+	for _, book := range books {
+		stats.Size += book.Size
+		count(formats, formatOf(book.Name), book.Size)
+		if book.Author != "" {
+			count(authors, book.Author, book.Size)
+		} else {
+			stats.Missing.Author++
+		}
+		if book.Series != "" {
+			count(series, book.Series, book.Size)
+		}
+		if book.Title == "" {
+			stats.Missing.Title++
+		}
+		if book.CoverPath == "" {
+			stats.Missing.Cover++
+		}
+	}
+
+	stats.Formats = sortedCounts(formats)
+	stats.Authors = sortedCounts(authors)
+	stats.Series = sortedCounts(series)
+
+	top := func(compare func(a, b CatalogEntry) int) []StatsBook {
+		sorted := slices.SortedFunc(slices.Values(books), func(a, b CatalogEntry) int {
+			return cmp.Or(compare(a, b), strings.Compare(a.Name, b.Name))
+		})
+		list := []StatsBook{}
+		for _, book := range sorted[:min(len(sorted), statsBooks)] {
+			list = append(list, StatsBook{
+				Path:    s.mountPath("/" + book.Name),
+				Title:   book.Title,
+				Author:  book.Author,
+				Size:    book.Size,
+				ModTime: book.ModTime,
+			})
+		}
+		return list
+	}
+	stats.Largest = top(func(a, b CatalogEntry) int { return cmp.Compare(b.Size, a.Size) })
+	stats.Recent = top(func(a, b CatalogEntry) int { return b.ModTime.Compare(a.ModTime) })
+	return stats
+}
+
+// sortedCounts returns the counts with the most books first
+func sortedCounts(counts map[string]*StatsCount) []StatsCount {
+	list := []StatsCount{}
+	for _, c := range counts {
+		list = append(list, *c)
+	}
+	slices.SortFunc(list, func(a, b StatsCount) int {
+		return cmp.Or(cmp.Compare(b.Books, a.Books), strings.Compare(a.Name, b.Name))
+	})
+	return list
+}
+
+// StatsHandler serves the Stats of the library to the admins, as JSON or as
+// a page in the HTML view, and a 503 until the index is ready. The other
+// users get a 404.
+func (s OPDS) StatsHandler(w http.ResponseWriter, req *http.Request) error {
+	s.User = UserFromContext(req.Context())
+	if !s.isAdmin() {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	var books []CatalogEntry
+	ok := false
+	if s.Index != nil {
+		books, ok = s.Index.books(s)
+	}
+	if !ok {
+		return serveIndexNotReady(w)
+	}
+	stats := s.stats(books)
+
+	w.Header().Set("Cache-Control", "no-store")
+	if s.EnableHTML && isBrowser(req) {
+		return s.renderStats(w, stats)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(stats)
+}
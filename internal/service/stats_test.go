@@ -0,0 +1,90 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	s := OPDS{
+		Storage: fstest.MapFS{
+			"Dune/1 Dune.epub":         {Data: testBook(t, "Dune", "Frank Herbert", "Dune", ""), ModTime: day},
+			"Dune/2 Dune Messiah.epub": {Data: testBook(t, "Dune Messiah", "Frank Herbert", "Dune", ""), ModTime: day.AddDate(0, 0, 2)},
+			"Austen/Emma.epub":         {Data: testBook(t, "Emma", "Jane Austen", "", ""), ModTime: day.AddDate(0, 0, 1)},
+			"Austen/Emma.pdf":          {Data: make([]byte, 5000), ModTime: day.AddDate(0, 0, 3)},
+			"unsorted/notes":           {Data: []byte("notes"), ModTime: day},
+			"Austen/.opdsignore":       {Data: []byte("*.txt\n")},
+			"Austen/Emma.txt":          {Data: []byte("ignored")},
+			"Austen/cover.jpg":         {Data: []byte("jpg")},
+		},
+		ExtractMetadata: true,
+		ShowCovers:      true,
+		Admins:          []string{"alice"},
+		Index:           &Index{},
+	}
+	s.Index.build(s)
+	t.Run("json", func(t *testing.T) {
+		w := testGet(t, s.StatsHandler, "/_stats", "alice")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var stats Stats
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+		assert.Equal(t, 5, stats.Books, "ignored files and covers are left out")
+		require.Len(t, stats.Formats, 3)
+		assert.Equal(t, StatsCount{Name: "epub", Books: 3, Size: stats.Size - 5000 - 5}, stats.Formats[0])
+		assert.Equal(t, StatsCount{Name: "none", Books: 1, Size: 5}, stats.Formats[1])
+		assert.Equal(t, StatsCount{Name: "pdf", Books: 1, Size: 5000}, stats.Formats[2])
+
+		require.Len(t, stats.Authors, 2)
+		assert.Equal(t, "Frank Herbert", stats.Authors[0].Name)
+		assert.Equal(t, 2, stats.Authors[0].Books)
+		assert.Equal(t, []StatsCount{{Name: "Dune", Books: 2, Size: stats.Authors[0].Size}}, stats.Series)
+		assert.Equal(t, StatsMissing{Title: 2, Author: 2, Cover: 5}, stats.Missing)
+
+		require.Len(t, stats.Largest, 5)
+		assert.Equal(t, "/Austen/Emma.pdf", stats.Largest[0].Path)
+		assert.Equal(t, "/unsorted/notes", stats.Largest[4].Path)
+		assert.Equal(t, "/Austen/Emma.pdf", stats.Recent[0].Path)
+		assert.Equal(t, "/Dune/2 Dune Messiah.epub", stats.Recent[1].Path)
+		assert.Equal(t, "Dune Messiah", stats.Recent[1].Title)
+	})
+
+	t.Run("index not ready", func(t *testing.T) {
+		s := s
+		// it is being built
+		s.Index = &Index{refreshing: true}
+		w := testGet(t, s.StatsHandler, "/_stats", "alice")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("admins only", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, testGet(t, s.StatsHandler, "/_stats", "bob").Code)
+		assert.Equal(t, http.StatusNotFound, testGet(t, s.StatsHandler, "/_stats", "").Code)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		s := s
+		s.EnableHTML = true
+		s.Prefix = "/fiction"
+		w := testGet(t, s.StatsHandler, "/_stats", "alice", "Accept", "text/html")
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+		body := w.Body.String()
+		assert.Contains(t, body, "<h2>5 books, ")
+		assert.Contains(t, body, `<tr><td>pdf</td><td class="number">1</td><td class="number">4.9 KB</td></tr>`)
+		assert.Contains(t, body, `<tr><td>Frank Herbert</td><td class="number">2</td></tr>`)
+		assert.Contains(t, body, `<tr><td>Without cover</td><td class="number">5</td></tr>`)
+		assert.Contains(t, body, `<a href="/fiction/_entry?file=%2FDune%2F2&#43;Dune&#43;Messiah.epub">Dune Messiah</a>`)
+		assert.Contains(t, body, `<a href="/fiction/_entry?file=%2FAusten%2FEmma.pdf">Emma.pdf</a>`)
+	})
+}
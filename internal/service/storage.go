@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage is an fs.FS with the books stored in a directory of the local disk.
+// Unlike os.DirFS it refuses to follow symlinks that point outside of Root.
+type LocalStorage struct {
+	Root string
+}
+
+// resolve returns the path in the disk of name after checking it stays under Root
+func (l LocalStorage) resolve(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	// the files that are not there, like most .opdsignore files, are not an error to log
+	p := filepath.Join(l.Root, filepath.FromSlash(name))
+	if _, err := os.Lstat(p); err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	// verifyPath avoid the http transversal by checking the path is under Root
+	p, err := verifyPath(p, l.Root)
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return p, nil
+}
+
+func (l LocalStorage) Open(name string) (fs.File, error) {
+	p, err := l.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (l LocalStorage) Stat(name string) (fs.FileInfo, error) {
+	p, err := l.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (l LocalStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := l.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+// storage returns the file system the books are read from
+func (s OPDS) storage() fs.FS {
+	if s.Storage != nil {
+		return s.Storage
+	}
+	return LocalStorage{Root: s.TrustedRoot}
+}
+
+// storagePath converts the path of a request in a name of the storage.
+// ok is false when the path tries to escape the root of the library.
+func storagePath(urlPath string) (name string, ok bool) {
+	name = strings.Trim(urlPath, "/")
+	if name == "" {
+		return currentDirectory, true
+	}
+	name = path.Clean(name)
+	return name, fs.ValidPath(name)
+}
+
+type readSeekerAt interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// openedFile is a book opened for random access, as needed to read ZIP and PDF
+// files and to serve ranges.
+type openedFile struct {
+	readSeekerAt
+	info  fs.FileInfo
+	close func() error
+}
+
+func (f *openedFile) Close() error {
+	return f.close()
+}
+
+// openFile opens name for random access. Files of storages that can't seek are
+// loaded in memory up to maxMemberBuffer.
+func openFile(fsys fs.FS, name string) (*openedFile, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+
+	if r, ok := f.(readSeekerAt); ok {
+		return &openedFile{readSeekerAt: r, info: info, close: f.Close}, nil
+	}
+
+	defer f.Close()
+	if info.Size() > maxMemberBuffer {
+		return nil, fmt.Errorf("%s is too big to be read in memory", name)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return &openedFile{readSeekerAt: bytes.NewReader(data), info: info, close: func() error { return nil }}, nil
+}
@@ -18,13 +18,20 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/dubyte/dir2opds/internal/service"
 )
@@ -32,32 +39,157 @@ import (
 var (
 	port             = flag.String("port", "8080", "The server will listen in this port.")
 	host             = flag.String("host", "0.0.0.0", "The server will listen in this host.")
-	dirRoot          = flag.String("dir", "./books", "A directory with books.")
+	dirRoot          = flag.String("dir", "./books", "A directory with books, or an S3 bucket as s3://bucket/prefix.")
 	debug            = flag.Bool("debug", false, "If it is set it will log the requests.")
 	hideCalibreFiles = flag.Bool("hide-calibre-files", true, "Hide files stored by calibre.")
 	hideDotFiles     = flag.Bool("hide-dot-files", true, "Hide files that starts with dot.")
 	noCache          = flag.Bool("no-cache", false, "adds reponse headers to avoid client from caching.")
 	enableCache      = flag.Bool("enable-cache", false, "Enable ETag and Last-Modified headers for conditional requests.")
+	metrics          = flag.Bool("metrics", false, "Serve Prometheus metrics of the requests, downloads, scans and errors at /metrics.")
 	gzip             = flag.Bool("gzip", false, "Enable gzip compression for responses.")
-	sortBy           = flag.String("sort", "name", "Sort entries by: name, date, size.")
+	sortBy           = flag.String("sort", "name", "Sort entries by: name, title, author, series, date, size.")
+	sortOrder        = flag.String("sort-order", "", "Sort order: asc, desc (default: newest and biggest first, names from A to Z).")
+	sortLocale       = flag.String("sort-locale", "", "The language whose alphabet orders the entries, e.g. 'de' or 'sv' (empty for the default Unicode order).")
+	sortArticles     = flag.String("sort-articles", "", "Leading articles to ignore when sorting, e.g. 'the,a,an'.")
+	mixedFolders     = flag.String("mixed-folders", service.MixedAcquisition, "How folders with books and subfolders are presented: acquisition (one feed of both), split (the subfolders plus a feed of the books).")
 	showCovers       = flag.Bool("show-covers", true, "Show cover.jpg or folder.jpg as catalog cover.")
 	mimeMapStr       = flag.String("mime-map", "", "Custom mime types (e.g., '.mobi:application/x-mobipocket-ebook,.azw3:application/vnd.amazon.ebook')")
 	searchEnable     = flag.Bool("search", false, "Enable basic filename search.")
 	extractMeta      = flag.Bool("extract-metadata", true, "Extract metadata (title, author, cover) from EPUB and PDF files.")
 	enableHTML       = flag.Bool("enable-html", false, "Enable web-friendly HTML view for browsers.")
+	relatedBooks     = flag.Bool("related-books", false, "Link each book to the others of its series, author and subjects across the library, from an index of the metadata. Needs -extract-metadata.")
+	hideDuplicates   = flag.Bool("hide-duplicates", false, "Hide all the copies of a book but the best one from the feeds and search, see the duplicates command.")
+	admin            = flag.String("admin", "", "Comma separated users and @groups that can use the admin endpoints /_duplicates and /_stats, * for everybody.")
+	indexRefresh     = flag.Duration("index-refresh", 10*time.Minute, "How often the index of the whole library for -related-books, -hide-duplicates and the admin endpoints is rebuilt.")
 	baseURL          = flag.String("url", "", "The base URL used for absolute links in the feed (e.g., https://opds.example.com).")
 	logFormat        = flag.String("log-format", "json", "Log format: json, text.")
 	pageSize         = flag.Int("page-size", 50, "Number of entries per page (0 for default, max 200).")
 	noPagination     = flag.Bool("no-pagination", false, "Disable pagination and show all entries in a single feed.")
+	zipDownload      = flag.Bool("zip-download", false, "Enable downloading a whole folder as a ZIP archive.")
+	browseZip        = flag.Bool("browse-zip", false, "Browse ZIP archives as folders and serve the books inside them.")
+	s3Endpoint       = flag.String("s3-endpoint", "https://s3.amazonaws.com", "The URL of the S3 compatible object store used when -dir is s3://bucket/prefix.")
+	s3Region         = flag.String("s3-region", "us-east-1", "The region of the S3 bucket.")
+	zipMaxSize       = flag.Int64("zip-max-size", 1024, "Maximum size in MB of a folder ZIP download (0 for no limit).")
+	configFile       = flag.String("config", "", "A YAML file with the options, flags and DIR2OPDS_* environment variables take precedence.")
+	socket           = flag.String("socket", "", "Listen in this Unix domain socket instead of host and port.")
+	tlsCert          = flag.String("tls-cert", "", "A PEM certificate file to serve HTTPS, reloaded when it changes.")
+	tlsKey           = flag.String("tls-key", "", "The PEM private key file of -tls-cert.")
+	redirectPort     = flag.String("redirect-port", "", "With TLS, redirect the HTTP requests of this port to HTTPS.")
+	htpasswd         = flag.String("htpasswd", "", "An htpasswd file with bcrypt hashes, when set the catalog asks for a user and password.")
+	aclFile          = flag.String("acl", "", "A YAML file with the folders each user or group can see, needs -htpasswd.")
+	signingKey       = flag.String("signing-key", "", "A file with the secret key to sign the links to the books and covers, so they work without credentials. Needs -htpasswd.")
+	signingTTL       = flag.Duration("signing-ttl", 48*time.Hour, "How long the signed links to the books and covers work.")
+
+	readHeaderTimeout = flag.Duration("read-header-timeout", 10*time.Second, "Maximum time to read the headers of a request.")
+	readTimeout       = flag.Duration("read-timeout", time.Minute, "Maximum time to read a whole request (0 for no limit).")
+	writeTimeout      = flag.Duration("write-timeout", 0, "Maximum time to write a response, it cuts slow downloads (0 for no limit).")
+	idleTimeout       = flag.Duration("idle-timeout", 2*time.Minute, "Maximum time to wait for the next request of a keep-alive connection.")
+	shutdownTimeout   = flag.Duration("shutdown-timeout", 30*time.Second, "Maximum time to wait for the requests in flight on SIGINT or SIGTERM (0 for no limit).")
 
 	// Will be deprecated in a future version; use -hide-calibre-files instead
 	calibre = flag.Bool("calibre", true, "Hide files stored by calibre. Will be deprecated; use -hide-calibre-files.")
+
+	libraries      listFlag
+	ignorePatterns listFlag
 )
 
+func init() {
+	flag.Var(&libraries, "library", "A library served under its own prefix as name=dir;option=value... (repeatable, replaces -dir).")
+	flag.Var(&ignorePatterns, "ignore", "A gitignore-style pattern of the files to hide in every library, like the ones of .opdsignore files (repeatable).")
+}
+
+// listFlag collects the values of a repeatable flag
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// commands are run with their name as the first argument instead of serving
+// the libraries, they take the same flags
+var commands = map[string]func(args []string) int{
+	"duplicates": runDuplicates,
+	"check":      runCheck,
+	"export":     runExport,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
 
 	flag.Parse()
 
+	cmdline := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
+	})
+
+	given, err := loadOptions(flag.CommandLine, cmdline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	warnDeprecated(given)
+
+	setupLogger()
+
+	fmt.Println(startValues())
+
+	h, err := newHandler(given)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	handler := &reloadableHandler{}
+	handler.current.Store(&h)
+	go handler.reloadOnSignal(flag.CommandLine, cmdline)
+
+	l, err := listen()
+	if err != nil {
+		slog.Error("listen failed", "error", err)
+		os.Exit(1)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	srv := newServer(handler)
+	srv.TLSConfig, err = newTLSConfig()
+	if err != nil {
+		slog.Error("tls failed", "error", err)
+		os.Exit(1)
+	}
+
+	var redirect *http.Server
+	if srv.TLSConfig != nil && *redirectPort != "" {
+		redirect, err = startRedirect(l)
+		if err != nil {
+			slog.Error("redirect listen failed", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	err = serve(srv, l, stop, *shutdownTimeout)
+	// os.Exit skips the deferred calls
+	if redirect != nil {
+		redirect.Close()
+	}
+	if err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func setupLogger() {
 	var level slog.Level
 	if *debug {
 		level = slog.LevelDebug
@@ -77,77 +209,395 @@ func main() {
 
 	logger := slog.New(handler).With("base_url", *baseURL)
 	slog.SetDefault(logger)
+}
 
-	// Use the absolute canonical path of the dir parm as the trustedRoot.
-	// Helps avoid http path traversal. https://github.com/dubyte/dir2opds/issues/17
-	absolutePath, err := absoluteCanonicalPath(*dirRoot)
+// commandLibraries parses args, the flags of a command, like the ones of the
+// server and returns the libraries it works on
+func commandLibraries(args []string) ([]service.Library, error) {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return nil, err
+	}
+	cmdline := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
+	})
+
+	given, err := loadOptions(flag.CommandLine, cmdline)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(1)
+		return nil, err
 	}
+	warnDeprecated(given)
+	setupLogger()
 
-	slog.Info("trusted root", "path", absolutePath)
+	s, err := newOPDS(given)
+	if err != nil {
+		return nil, err
+	}
+	if len(libraries) > 0 {
+		return parseLibraries(libraries, s)
+	}
+	s.TrustedRoot, s.Storage, err = newStorage(*dirRoot)
+	if err != nil {
+		return nil, err
+	}
+	return []service.Library{{OPDS: s}}, nil
+}
 
-	fmt.Println(startValues())
+// runReport runs a command that prints a report of each library, as JSON with
+// -json. report returns the report of a library and whether it found what
+// makes the command exit with found, failure is the exit code when it can't
+// run.
+func runReport[R interface{ Print(io.Writer) error }](args []string, usage string, report func(service.OPDS) (R, bool), found, failure int) int {
+	jsonOutput := flag.Bool("json", false, "Print the report as JSON.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n\n", usage)
+		flag.PrintDefaults()
+	}
 
-	hideCalibre := *hideCalibreFiles
-	calibreExplicit := false
-	hideCalibreExplicit := false
-	flag.Visit(func(f *flag.Flag) {
-		switch f.Name {
-		case "calibre":
-			calibreExplicit = true
-		case "hide-calibre-files":
-			hideCalibreExplicit = true
+	libs, err := commandLibraries(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return failure
+	}
+
+	reports := make(map[string]R, len(libs))
+	code := 0
+	for _, lib := range libs {
+		r, ok := report(lib.OPDS)
+		reports[lib.Name] = r
+		if ok {
+			code = found
 		}
-	})
+	}
 
-	if hideCalibreExplicit {
-		hideCalibre = *hideCalibreFiles
-	} else if calibreExplicit {
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if len(libs) == 1 && libs[0].Name == "" {
+			err = enc.Encode(reports[""])
+		} else {
+			err = enc.Encode(reports)
+		}
+	} else {
+		for _, lib := range libs {
+			if err = reports[lib.Name].Print(os.Stdout); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return failure
+	}
+	return code
+}
+
+// warnDeprecated warns about the deprecated flags that were given, once at
+// the start and not on every reload
+func warnDeprecated(given map[string]bool) {
+	if !given["hide-calibre-files"] && given["calibre"] {
 		fmt.Fprintf(os.Stderr, "Warning: -calibre will be deprecated in a future version, use -hide-calibre-files instead\n")
+	}
+}
+
+// newOPDS returns the options shared by the libraries from the flags, given
+// holds the names of the flags set in the command line, the environment or the
+// config file.
+func newOPDS(given map[string]bool) (service.OPDS, error) {
+	hideCalibre := *hideCalibreFiles
+	if !given["hide-calibre-files"] && given["calibre"] {
 		hideCalibre = *calibre
 	}
 
 	s := service.OPDS{
-		TrustedRoot:      absolutePath,
-		HideCalibreFiles: hideCalibre,
-		HideDotFiles:     *hideDotFiles,
-		NoCache:          *noCache,
-		EnableCache:      *enableCache,
-		SortBy:           *sortBy,
-		ShowCovers:       *showCovers,
-		MimeMap:          parseMimeMap(*mimeMapStr),
-		EnableSearch:     *searchEnable,
-		ExtractMetadata:  *extractMeta,
-		EnableHTML:       *enableHTML,
-		BaseURL:          *baseURL,
-		PageSize:         *pageSize,
-		NoPagination:     *noPagination,
-	}
-
-	http.HandleFunc("/", errorHandler(s.Handler))
-	http.HandleFunc("/health", service.HealthHandler)
-	if *searchEnable {
-		http.HandleFunc("/search", errorHandler(s.SearchHandler))
-		http.HandleFunc("/opensearch.xml", s.OpenSearchHandler)
-	}
-	if *extractMeta {
-		http.HandleFunc("/cover", errorHandler(s.CoverHandler))
-	}
-
-	var httpHandler http.Handler = http.DefaultServeMux
+		HideCalibreFiles:  hideCalibre,
+		HideDotFiles:      *hideDotFiles,
+		NoCache:           *noCache,
+		EnableCache:       *enableCache,
+		SortBy:            *sortBy,
+		SortOrder:         *sortOrder,
+		MixedFolders:      *mixedFolders,
+		SortLocale:        *sortLocale,
+		SortArticles:      parseList(*sortArticles),
+		ShowCovers:        *showCovers,
+		MimeMap:           parseMimeMap(*mimeMapStr),
+		EnableSearch:      *searchEnable,
+		ExtractMetadata:   *extractMeta,
+		EnableHTML:        *enableHTML,
+		BaseURL:           *baseURL,
+		PageSize:          *pageSize,
+		NoPagination:      *noPagination,
+		EnableZipDownload: *zipDownload,
+		ZipMaxSize:        *zipMaxSize << 20,
+		BrowseArchives:    *browseZip,
+		EnableAuth:        *htpasswd != "",
+		Ignore:            ignorePatterns,
+		RelatedBooks:      *relatedBooks,
+		HideDuplicates:    *hideDuplicates,
+		Admins:            parseList(*admin),
+	}
+
+	if *aclFile != "" {
+		var err error
+		s.ACL, err = service.LoadACL(*aclFile)
+		if err != nil {
+			return s, fmt.Errorf("reading acl: %w", err)
+		}
+	}
+
+	if *signingKey != "" {
+		key, err := service.LoadSigningKey(*signingKey)
+		if err != nil {
+			return s, fmt.Errorf("reading signing key: %w", err)
+		}
+		s.Signer = &service.URLSigner{Key: key, TTL: *signingTTL}
+	}
+	return s, nil
+}
+
+// newHandler builds the handler of the server from the flags, given holds the
+// names of the flags set in the command line, the environment or the config file.
+func newHandler(given map[string]bool) (http.Handler, error) {
+	s, err := newOPDS(given)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	handle(mux, "/health", "health", service.HealthHandler)
+	if *metrics {
+		mux.HandleFunc("/metrics", service.MetricsHandler)
+	}
+
+	if len(libraries) == 0 {
+		s.TrustedRoot, s.Storage, err = newStorage(*dirRoot)
+		if err != nil {
+			return nil, err
+		}
+		mux.Handle("/", routes(s))
+	} else {
+		libs, err := parseLibraries(libraries, s)
+		if err != nil {
+			return nil, err
+		}
+		for _, lib := range libs {
+			mux.Handle(lib.OPDS.Prefix+"/", http.StripPrefix(lib.OPDS.Prefix, routes(lib.OPDS)))
+		}
+		handle(mux, "/", "feed", errorHandler(service.Libraries{
+			BaseURL:    *baseURL,
+			EnableHTML: *enableHTML,
+			NoCache:    *noCache,
+			EnableAuth: s.EnableAuth,
+			ACL:        s.ACL,
+			Libraries:  libs,
+		}.Handler))
+	}
+
+	var httpHandler http.Handler = mux
+	if *htpasswd != "" {
+		users, err := service.LoadHtpasswd(*htpasswd)
+		if err != nil {
+			return nil, fmt.Errorf("reading users: %w", err)
+		}
+		if *tlsCert == "" {
+			slog.Warn("basic authentication without -tls-cert sends the passwords in clear text")
+		}
+		httpHandler = service.BasicAuth{
+			Users:   users,
+			BaseURL: *baseURL,
+			Public:  []string{"/health"},
+			Signer:  s.Signer,
+		}.Middleware(httpHandler)
+	}
 	if *gzip {
 		slog.Info("gzip compression enabled")
 		httpHandler = service.GzipMiddleware(httpHandler)
 	}
+	return httpHandler, nil
+}
 
-	if err := http.ListenAndServe(*host+":"+*port, httpHandler); err != nil {
-		slog.Error("server failed", "error", err)
-		os.Exit(1)
+// indexes are the indexes started by the last handler built, the reload
+// stops them once their handler is replaced
+var indexes []*service.Index
+
+// stopIndexes stops the indexes x
+func stopIndexes(x []*service.Index) {
+	for _, index := range x {
+		index.Stop()
 	}
 }
 
+// routes returns the handlers of a library
+func routes(s service.OPDS) *http.ServeMux {
+	if s.RelatedBooks || s.HideDuplicates || len(s.Admins) > 0 {
+		// each library indexes its own books
+		s.Index = &service.Index{Refresh: *indexRefresh}
+		s.Index.Start(s)
+		indexes = append(indexes, s.Index)
+	}
+
+	mux := http.NewServeMux()
+	// the feed handler moves the books it serves to the download route
+	handle(mux, "/", "feed", errorHandler(s.Handler))
+	handle(mux, "/_entry", "entry", errorHandler(s.EntryHandler))
+	if s.EnableSearch {
+		handle(mux, "/search", "search", errorHandler(s.SearchHandler))
+		handle(mux, "/opensearch.xml", "search", s.OpenSearchHandler)
+	}
+	if s.ExtractMetadata {
+		handle(mux, "/cover", "cover", errorHandler(s.CoverHandler))
+	}
+	if s.EnableZipDownload {
+		handle(mux, "/zip", "download", errorHandler(s.ZipHandler))
+	}
+	if len(s.Admins) > 0 {
+		handle(mux, "/_duplicates", "admin", errorHandler(s.DuplicatesHandler))
+		handle(mux, "/_stats", "admin", errorHandler(s.StatsHandler))
+	}
+	return mux
+}
+
+// handle registers the handler of pattern, counted in the metrics of route
+// with -metrics
+func handle(mux *http.ServeMux, pattern, route string, h http.HandlerFunc) {
+	if *metrics {
+		mux.Handle(pattern, service.Observe(route, h))
+		return
+	}
+	mux.Handle(pattern, h)
+}
+
+// newStorage returns where the books of dir are read from: the trusted root
+// in the local disk, or an S3 storage when dir is s3://bucket/prefix.
+func newStorage(dir string) (string, fs.FS, error) {
+	if bucket, prefix, ok := parseS3URL(dir); ok {
+		slog.Info("s3 storage", "endpoint", *s3Endpoint, "bucket", bucket, "prefix", prefix)
+		return "", service.S3Storage{
+			Endpoint:  *s3Endpoint,
+			Region:    *s3Region,
+			Bucket:    bucket,
+			Prefix:    prefix,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}, nil
+	}
+
+	// Use the absolute canonical path of the dir parm as the trustedRoot.
+	// Helps avoid http path traversal. https://github.com/dubyte/dir2opds/issues/17
+	absolutePath, err := absoluteCanonicalPath(dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	slog.Info("trusted root", "path", absolutePath)
+	return absolutePath, nil, nil
+}
+
+// parseLibraries builds the libraries of the -library flags with their own storage
+func parseLibraries(specs []string, base service.OPDS) ([]service.Library, error) {
+	var libs []service.Library
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		lib, dir, err := parseLibrary(spec, base)
+		if err != nil {
+			return nil, err
+		}
+		if seen[lib.Name] {
+			return nil, fmt.Errorf("library %q is defined twice", lib.Name)
+		}
+		seen[lib.Name] = true
+
+		lib.OPDS.TrustedRoot, lib.OPDS.Storage, err = newStorage(dir)
+		if err != nil {
+			return nil, fmt.Errorf("library %q: %w", lib.Name, err)
+		}
+		libs = append(libs, lib)
+	}
+	return libs, nil
+}
+
+// parseList splits a comma separated list like 'the,a,an'
+func parseList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseLibrary reads a -library value like fiction=/srv/fiction;sort=date;hide-dot-files=false.
+// The options that are not given are taken from base.
+func parseLibrary(spec string, base service.OPDS) (service.Library, string, error) {
+	parts := strings.Split(spec, ";")
+	name, dir, ok := strings.Cut(parts[0], "=")
+	name = strings.TrimSpace(name)
+	dir = strings.TrimSpace(dir)
+	if !ok || dir == "" {
+		return service.Library{}, "", fmt.Errorf("library %q: expected name=dir", spec)
+	}
+	if !service.ValidLibraryName(name) {
+		return service.Library{}, "", fmt.Errorf("library %q: invalid name, use letters, digits, '.', '_' and '-' but not health, metrics or authentication.json", name)
+	}
+
+	lib := service.Library{Name: name, OPDS: base}
+	lib.OPDS.Prefix = "/" + name
+
+	for _, option := range parts[1:] {
+		if strings.TrimSpace(option) == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch key {
+		case "title":
+			lib.Title = value
+		case "sort":
+			if !service.ValidSort(value) {
+				return service.Library{}, "", fmt.Errorf("library %q: sort %q: must be one of %s", name, value, strings.Join(service.SortKeys(), ", "))
+			}
+			if service.SortNeedsMetadata(value) && !lib.OPDS.ExtractMetadata {
+				return service.Library{}, "", fmt.Errorf("library %q: sort %s needs -extract-metadata", name, value)
+			}
+			lib.OPDS.SortBy = value
+		case "order":
+			if !service.ValidSortOrder(value) {
+				return service.Library{}, "", fmt.Errorf("library %q: order %q: must be asc or desc", name, value)
+			}
+			lib.OPDS.SortOrder = value
+		case "mixed-folders":
+			if !service.ValidMixedFolders(value) {
+				return service.Library{}, "", fmt.Errorf("library %q: mixed-folders %q: must be acquisition or split", name, value)
+			}
+			lib.OPDS.MixedFolders = value
+		case "mime-map":
+			if err := validateMimeMap(value); err != nil {
+				return service.Library{}, "", fmt.Errorf("library %q: mime-map: %w", name, err)
+			}
+			lib.OPDS.MimeMap = parseMimeMap(value)
+		case "hide-calibre-files":
+			lib.OPDS.HideCalibreFiles, err = strconv.ParseBool(value)
+		case "hide-dot-files":
+			lib.OPDS.HideDotFiles, err = strconv.ParseBool(value)
+		case "show-covers":
+			lib.OPDS.ShowCovers, err = strconv.ParseBool(value)
+		case "hide-duplicates":
+			lib.OPDS.HideDuplicates, err = strconv.ParseBool(value)
+		default:
+			return service.Library{}, "", fmt.Errorf("library %q: unknown option %q", name, key)
+		}
+		if err != nil {
+			return service.Library{}, "", fmt.Errorf("library %q: option %s: %w", name, key, err)
+		}
+	}
+
+	return lib, dir, nil
+}
+
 func parseMimeMap(s string) map[string]string {
 	if s == "" {
 		return nil
@@ -163,7 +613,20 @@ func parseMimeMap(s string) map[string]string {
 	return m
 }
 
+// parseS3URL splits s3://bucket/prefix in its bucket and prefix
+func parseS3URL(dir string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(dir, "s3://")
+	if !ok {
+		return "", "", false
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	return bucket, strings.Trim(prefix, "/"), bucket != ""
+}
+
 func startValues() string {
+	if *socket != "" {
+		return "listening in: " + *socket
+	}
 	result := fmt.Sprintf("listening in: %s:%s", *host, *port)
 	return result
 }
@@ -174,6 +637,7 @@ func errorHandler(f func(http.ResponseWriter, *http.Request) error) http.Handler
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			slog.Error("request error", "uri", r.RequestURI, "error", err)
+			service.RequestError(r)
 		}
 	}
 }
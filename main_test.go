@@ -9,8 +9,11 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 
+	"github.com/dubyte/dir2opds/internal/service"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestStartValues(t *testing.T) {
@@ -58,6 +61,26 @@ func TestErrorHandler(t *testing.T) {
 	assert.Contains(t, buf.String(), `"error":"scary error"`)
 }
 
+func TestRoutes(t *testing.T) {
+	mux := routes(service.OPDS{Storage: fstest.MapFS{
+		"entry/Dune.epub": {Data: []byte("dune")},
+	}})
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	// a folder can have the name of a route
+	w := get("/entry")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<title>Dune.epub</title>")
+
+	w = get("/_entry?file=%2Fentry%2FDune.epub")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<title>Dune.epub</title>")
+}
+
 func Test_absoluteCanonicalPath(t *testing.T) {
 	wd, err := os.Getwd()
 	if err != nil {
@@ -89,3 +112,85 @@ func Test_absoluteCanonicalPath(t *testing.T) {
 		})
 	}
 }
+
+func TestParseS3URL(t *testing.T) {
+	bucket, prefix, ok := parseS3URL("s3://books/library/fiction/")
+	assert.True(t, ok)
+	assert.Equal(t, "books", bucket)
+	assert.Equal(t, "library/fiction", prefix)
+
+	bucket, prefix, ok = parseS3URL("s3://books")
+	assert.True(t, ok)
+	assert.Equal(t, "books", bucket)
+	assert.Equal(t, "", prefix)
+
+	_, _, ok = parseS3URL("./books")
+	assert.False(t, ok)
+
+	_, _, ok = parseS3URL("s3://")
+	assert.False(t, ok)
+}
+
+func TestParseList(t *testing.T) {
+	assert.Equal(t, []string{"the", "a", "an", "l'"}, parseList(" the, a,an,, l'"))
+	assert.Nil(t, parseList(""))
+}
+
+func TestParseLibrary(t *testing.T) {
+	base := service.OPDS{SortBy: "name", HideDotFiles: true, ShowCovers: true}
+
+	lib, dir, err := parseLibrary("comics=/mnt/nas/comics;title=Comics;sort=date;order=asc;mixed-folders=split;hide-duplicates=true;hide-dot-files=false;mime-map=.cbz:application/x-cbz", base)
+	require.NoError(t, err)
+	assert.Equal(t, "/mnt/nas/comics", dir)
+	assert.Equal(t, "comics", lib.Name)
+	assert.Equal(t, "Comics", lib.Title)
+	assert.Equal(t, "/comics", lib.OPDS.Prefix)
+	assert.Equal(t, "date", lib.OPDS.SortBy)
+	assert.Equal(t, "asc", lib.OPDS.SortOrder)
+	assert.Equal(t, service.MixedSplit, lib.OPDS.MixedFolders)
+	assert.True(t, lib.OPDS.HideDuplicates)
+	assert.False(t, lib.OPDS.HideDotFiles)
+	assert.True(t, lib.OPDS.ShowCovers)
+	assert.Equal(t, map[string]string{".cbz": "application/x-cbz"}, lib.OPDS.MimeMap)
+
+	lib, dir, err = parseLibrary("fiction=/srv/fiction", base)
+	require.NoError(t, err)
+	assert.Equal(t, "/srv/fiction", dir)
+	assert.Equal(t, "name", lib.OPDS.SortBy)
+	assert.True(t, lib.OPDS.HideDotFiles)
+
+	for _, spec := range []string{
+		"/srv/fiction",
+		"fiction=",
+		"a/b=/srv/fiction",
+		"health=/srv/fiction",
+		"metrics=/srv/fiction",
+		"authentication.json=/srv/fiction",
+		"fiction=/srv/fiction;colour=blue",
+		"fiction=/srv/fiction;hide-dot-files=maybe",
+		"fiction=/srv/fiction;sort=colour",
+		"fiction=/srv/fiction;sort=title",
+		"fiction=/srv/fiction;order=up",
+		"fiction=/srv/fiction;mixed-folders=both",
+		"fiction=/srv/fiction;mime-map=mobi",
+	} {
+		_, _, err := parseLibrary(spec, base)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseLibraries(t *testing.T) {
+	dir := t.TempDir()
+
+	libs, err := parseLibraries([]string{"fiction=" + dir, "comics=" + dir}, service.OPDS{})
+	require.NoError(t, err)
+	require.Len(t, libs, 2)
+	assert.Equal(t, "/fiction", libs[0].OPDS.Prefix)
+	assert.NotEmpty(t, libs[0].OPDS.TrustedRoot)
+
+	_, err = parseLibraries([]string{"fiction=" + dir, "fiction=" + dir}, service.OPDS{})
+	assert.Error(t, err)
+
+	_, err = parseLibraries([]string{"fiction=" + filepath.Join(dir, "missing")}, service.OPDS{})
+	assert.Error(t, err)
+}
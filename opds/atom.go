@@ -15,14 +15,16 @@ func Time(t time.Time) TimeStr {
 
 // Feed is an Atom feed.
 type Feed struct {
-	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
-	Title   string   `xml:"title"`
-	ID      string   `xml:"id"`
-	Link    []Link   `xml:"link"`
-	Updated TimeStr  `xml:"updated"`
-	Author  *Person  `xml:"author"`
-	Entry   []*Entry `xml:"entry"`
-	Opds    string   `xml:"xmlns:opds,attr,omitempty"`
+	XMLName  xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string   `xml:"title"`
+	Subtitle *Text    `xml:"subtitle"`
+	ID       string   `xml:"id"`
+	Link     []Link   `xml:"link"`
+	Updated  TimeStr  `xml:"updated"`
+	Author   *Person  `xml:"author"`
+	Entry    []*Entry `xml:"entry"`
+	Opds     string   `xml:"xmlns:opds,attr,omitempty"`
+	Thr      string   `xml:"xmlns:thr,attr,omitempty"`
 }
 
 type Entry struct {
@@ -36,16 +38,28 @@ type Entry struct {
 	Content          *Text      `xml:"content"`
 	DcSeries         string     `xml:"dc:series,omitempty"`
 	DcSeriesPosition string     `xml:"dc:seriesPosition,omitempty"`
+	DcLanguage       string     `xml:"dc:language,omitempty"`
 	Categories       []Category `xml:"category"`
 }
 
+// EntryDocument is a standalone Atom entry, like the complete entry of an
+// OPDS catalog entry.
+type EntryDocument struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom entry"`
+	*Entry
+	Dc   string `xml:"xmlns:dc,attr"`
+	Opds string `xml:"xmlns:opds,attr,omitempty"`
+}
+
 type Category struct {
 	Term   string `xml:"term,attr"`
 	Label  string `xml:"label,attr,omitempty"`
 	Scheme string `xml:"scheme,attr,omitempty"`
 }
 
-// Link is an Atom link with optional OPDS 1.2 facet attributes.
+// Link is an Atom link with optional OPDS 1.2 facet attributes, Count is the
+// number of entries of a facet, zero included, and needs the thr namespace in
+// the feed.
 type Link struct {
 	Rel         string `xml:"rel,attr,omitempty"`
 	Href        string `xml:"href,attr"`
@@ -55,6 +69,7 @@ type Link struct {
 	Length      uint   `xml:"length,attr,omitempty"`
 	FacetGroup  string `xml:"http://opds-spec.org/2010/catalog facetGroup,attr,omitempty"`
 	ActiveFacet string `xml:"http://opds-spec.org/2010/catalog activeFacet,attr,omitempty"`
+	Count       *int   `xml:"thr:count,attr,omitempty"`
 }
 
 // Person is an Atom person (author or contributor).
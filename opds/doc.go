@@ -43,12 +43,14 @@
 //   - "http://opds-spec.org/image": cover image link
 //   - "http://opds-spec.org/image/thumbnail": thumbnail image link
 //   - "first", "previous", "next", "last": pagination links
+//   - "alternate": link from a partial entry to its complete entry
 //
 // # Content Types
 //
 // Standard OPDS media types:
 //   - Navigation feed: application/atom+xml;profile=opds-catalog;kind=navigation
 //   - Acquisition feed: application/atom+xml;profile=opds-catalog;kind=acquisition
+//   - Complete entry: application/atom+xml;type=entry;profile=opds-catalog
 //   - OpenSearch description: application/opensearchdescription+xml
 //
 // For more information about OPDS, visit https://opds-spec.org.
@@ -17,6 +17,10 @@ func (f feedBuilder) Title(title string) feedBuilder {
 	return builder.Set(f, "Title", title).(feedBuilder)
 }
 
+func (f feedBuilder) Subtitle(subtitle *Text) feedBuilder {
+	return builder.Set(f, "Subtitle", subtitle).(feedBuilder)
+}
+
 func (f feedBuilder) ID(id string) feedBuilder {
 	return builder.Set(f, "ID", id).(feedBuilder)
 }
@@ -38,6 +38,10 @@ func (l linkBuilder) ActiveFacet(active string) linkBuilder {
 	return builder.Set(l, "ActiveFacet", active).(linkBuilder)
 }
 
+func (l linkBuilder) Count(count int) linkBuilder {
+	return builder.Set(l, "Count", &count).(linkBuilder)
+}
+
 func (l linkBuilder) Build() Link {
 	return builder.GetStruct(l).(Link)
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync/atomic"
+	"syscall"
+)
+
+// reloadableHandler serves the requests with the handler built from the last
+// configuration that loaded fine. Requests in flight keep the handler they
+// started with when it is swapped.
+type reloadableHandler struct {
+	current atomic.Pointer[http.Handler]
+}
+
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*h.current.Load()).ServeHTTP(w, r)
+}
+
+// reloadOnSignal reloads the configuration on every SIGHUP
+func (h *reloadableHandler) reloadOnSignal(fset *flag.FlagSet, cmdline map[string]bool) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		_ = h.reload(fset, cmdline)
+	}
+}
+
+// reload reads the environment and the config file again and swaps the handler,
+// stopping the indexes of the old one. On error the current handler is kept.
+// The listener and its timeouts need a restart.
+func (h *reloadableHandler) reload(fset *flag.FlagSet, cmdline map[string]bool) error {
+	restore := saveFlags(fset)
+	given, err := loadOptions(fset, cmdline)
+	if err != nil {
+		slog.Error("reload failed, keeping the current config", "error", err)
+		return err
+	}
+
+	current := indexes
+	indexes = nil
+	next, err := newHandler(given)
+	if err != nil {
+		stopIndexes(indexes)
+		indexes = current
+		restore()
+		slog.Error("reload failed, keeping the current config", "error", err)
+		return err
+	}
+
+	setupLogger()
+	h.current.Store(&next)
+	// the requests in flight serve what the old indexes have
+	stopIndexes(current)
+	slog.Info("config reloaded")
+	return nil
+}
+
+// loadOptions sets the flags that are not in cmdline back to their defaults, then
+// loads them from the environment and the config file and validates them.
+// It returns the names of the flags that were given anywhere. On error the
+// flags keep the values they had.
+func loadOptions(fset *flag.FlagSet, cmdline map[string]bool) (given map[string]bool, err error) {
+	restore := saveFlags(fset)
+	defer func() {
+		if err != nil {
+			restore()
+		}
+	}()
+
+	fset.VisitAll(func(f *flag.Flag) {
+		if cmdline[f.Name] {
+			return
+		}
+		if l, ok := f.Value.(*listFlag); ok {
+			*l = nil
+			return
+		}
+		_ = f.Value.Set(f.DefValue)
+	})
+
+	configPath := *configFile
+	if configPath == "" {
+		configPath = os.Getenv(envName("config"))
+	}
+
+	given = make(map[string]bool, len(cmdline))
+	maps.Copy(given, cmdline)
+	if err := loadConfig(fset, given, configPath, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := validateFlags(); err != nil {
+		return nil, fmt.Errorf("invalid options:\n%w", err)
+	}
+	return given, nil
+}
+
+// saveFlags returns a function that sets the flags of fset back to the values
+// they have now
+func saveFlags(fset *flag.FlagSet) (restore func()) {
+	values := make(map[string]string)
+	lists := make(map[string]listFlag)
+	fset.VisitAll(func(f *flag.Flag) {
+		if l, ok := f.Value.(*listFlag); ok {
+			lists[f.Name] = slices.Clone(*l)
+			return
+		}
+		values[f.Name] = f.Value.String()
+	})
+
+	return func() {
+		fset.VisitAll(func(f *flag.Flag) {
+			if l, ok := f.Value.(*listFlag); ok {
+				*l = lists[f.Name]
+				return
+			}
+			_ = f.Value.Set(values[f.Name])
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	// keeps the flags of the test binary
+	cmdline := make(map[string]bool)
+	for name := range givenFlags(flag.CommandLine) {
+		if strings.HasPrefix(name, "test.") {
+			cmdline[name] = true
+		}
+	}
+	t.Cleanup(func() {
+		_, _ = loadOptions(flag.CommandLine, cmdline)
+	})
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "book.txt"), []byte("book"), 0o644))
+	config := filepath.Join(dir, "config.yaml")
+	t.Setenv("DIR2OPDS_CONFIG", config)
+
+	get := func(h http.Handler) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Body.String()
+	}
+
+	require.NoError(t, os.WriteFile(config, []byte("dir: "+dir+"\nmime-map: .txt:text/x-one\n"), 0o644))
+	h := &reloadableHandler{}
+	require.NoError(t, h.reload(flag.CommandLine, cmdline))
+	assert.Contains(t, get(h), `type="text/x-one"`)
+
+	old := *h.current.Load()
+
+	require.NoError(t, os.WriteFile(config, []byte("dir: "+dir+"\nmime-map: .txt:text/x-two\n"), 0o644))
+	require.NoError(t, h.reload(flag.CommandLine, cmdline))
+	assert.Contains(t, get(h), `type="text/x-two"`)
+	// a request that started before the reload keeps the old settings
+	assert.Contains(t, get(old), `type="text/x-one"`)
+
+	t.Run("invalid config keeps the current one", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(config, []byte("dir: "+dir+"\nsort: colour\n"), 0o644))
+		assert.Error(t, h.reload(flag.CommandLine, cmdline))
+		assert.Contains(t, get(h), `type="text/x-two"`)
+		// the flags keep the config that loaded fine
+		assert.Equal(t, "name", *sortBy)
+		assert.Equal(t, ".txt:text/x-two", *mimeMapStr)
+
+		require.NoError(t, os.WriteFile(config, []byte("dir: "+filepath.Join(dir, "missing")+"\n"), 0o644))
+		assert.Error(t, h.reload(flag.CommandLine, cmdline))
+		assert.Contains(t, get(h), `type="text/x-two"`)
+		assert.Equal(t, dir, *dirRoot)
+	})
+
+	t.Run("the indexes of the old handler are stopped", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(config, []byte("dir: "+dir+"\nextract-metadata: true\nrelated-books: true\n"), 0o644))
+		require.NoError(t, h.reload(flag.CommandLine, cmdline))
+		require.Len(t, indexes, 1)
+		first := indexes[0]
+
+		require.NoError(t, h.reload(flag.CommandLine, cmdline))
+		require.Len(t, indexes, 1)
+		assert.NotSame(t, first, indexes[0])
+
+		require.NoError(t, os.WriteFile(config, []byte("dir: "+filepath.Join(dir, "missing")+"\nextract-metadata: true\nrelated-books: true\n"), 0o644))
+		current := indexes[0]
+		assert.Error(t, h.reload(flag.CommandLine, cmdline))
+		assert.Equal(t, []*service.Index{current}, indexes, "a failed reload keeps them")
+	})
+}
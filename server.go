@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// listen returns the listener of the server: the socket passed by systemd when
+// the service is socket activated, the Unix domain socket of -socket, or host:port.
+func listen() (net.Listener, error) {
+	if l, ok, err := systemdListener(); ok || err != nil {
+		return l, err
+	}
+
+	if *socket != "" {
+		// a socket left by a previous run that was killed would make the listen
+		// fail, the one of a server still running is not stale
+		if info, err := os.Lstat(*socket); err == nil && info.Mode().Type() == fs.ModeSocket {
+			if conn, err := net.Dial("unix", *socket); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("socket %s: another server is listening in it", *socket)
+			}
+			if err := os.Remove(*socket); err != nil {
+				return nil, fmt.Errorf("removing stale socket: %w", err)
+			}
+		}
+		return net.Listen("unix", *socket)
+	}
+
+	return net.Listen("tcp", net.JoinHostPort(*host, *port))
+}
+
+// systemdListener returns the first socket passed by systemd, see sd_listen_fds(3).
+// ok is false when the process was not socket activated.
+func systemdListener() (l net.Listener, ok bool, err error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, false, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, false, nil
+	}
+
+	// the sockets are not for the children of the process
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if n > 1 {
+		slog.Warn("systemd passed more than one socket, only the first one is used", "count", n)
+	}
+
+	f := os.NewFile(listenFDsStart, "LISTEN_FD_3")
+	defer f.Close()
+	l, err = net.FileListener(f)
+	if err != nil {
+		return nil, true, fmt.Errorf("systemd socket: %w", err)
+	}
+	slog.Info("systemd socket activation", "address", l.Addr().String())
+	return l, true, nil
+}
+
+// newServer returns the http.Server with the timeouts of the flags
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
+}
+
+// serve serves the requests of l, over TLS when srv.TLSConfig is set, until a
+// signal arrives in stop. Then it stops accepting connections and waits up to
+// timeout for the requests in flight.
+func serve(srv *http.Server, l net.Listener, stop <-chan os.Signal, timeout time.Duration) error {
+	shutdown := make(chan error, 1)
+	go func() {
+		sig := <-stop
+		slog.Info("shutting down", "signal", sig.String(), "timeout", timeout)
+
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
+	var err error
+	if srv.TLSConfig != nil {
+		// the certificate comes from TLSConfig.GetCertificate
+		err = srv.ServeTLS(l, "", "")
+	} else {
+		err = srv.Serve(l)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	if err := <-shutdown; err != nil {
+		// the requests still running after the timeout are cut
+		srv.Close()
+		return fmt.Errorf("graceful shutdown: %w", err)
+	}
+	return nil
+}
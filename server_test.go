@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(newServer(handler), l, stop, time.Minute)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		response <- result{string(body), err}
+	}()
+
+	<-started
+	stop <- syscall.SIGTERM
+
+	// the server stops accepting connections but waits for the download in flight
+	require.Eventually(t, func() bool {
+		_, err := net.Dial("tcp", l.Addr().String())
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	close(release)
+
+	r := <-response
+	require.NoError(t, r.err)
+	assert.Equal(t, "done", r.body)
+	assert.NoError(t, <-served)
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(newServer(handler), l, stop, 50*time.Millisecond)
+	}()
+
+	go http.Get("http://" + l.Addr().String())
+	<-started
+	stop <- os.Interrupt
+
+	assert.ErrorContains(t, <-served, "graceful shutdown")
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	oldSocket := *socket
+	defer func() { *socket = oldSocket }()
+
+	*socket = filepath.Join(t.TempDir(), "dir2opds.sock")
+
+	// a socket left behind by a killed server
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: *socket, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	l, err := listen()
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, "unix", l.Addr().Network())
+	assert.Equal(t, "listening in: "+*socket, startValues())
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	client := http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", *socket)
+		},
+	}}
+	resp, err := client.Get("http://dir2opds/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+
+	// the socket of a running server is left alone
+	_, err = listen()
+	assert.ErrorContains(t, err, "another server is listening in it")
+	_, err = os.Lstat(*socket)
+	assert.NoError(t, err)
+}
+
+func TestSystemdListenerNotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	l, ok, err := systemdListener()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, l)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the certificate of certFile and keyFile, and loads them
+// again when they change on disk, so renewed certificates need no restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+	checked time.Time
+}
+
+// newCertReloader loads the certificate, it fails when it can't be loaded
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.checked = time.Now()
+	return c, nil
+}
+
+func (c *certReloader) load() error {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return fmt.Errorf("tls certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls key: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading tls certificate: %w", err)
+	}
+
+	c.cert = &cert
+	c.certMod = certInfo.ModTime()
+	c.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// changed reports whether the files were modified since they were loaded
+func (c *certReloader) changed() bool {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(c.certMod) || !keyInfo.ModTime().Equal(c.keyMod)
+}
+
+// GetCertificate is used as tls.Config.GetCertificate. A certificate that fails
+// to load is logged and the previous one keeps being served.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now := time.Now(); now.Sub(c.checked) >= certCheckInterval {
+		c.checked = now
+		if c.changed() {
+			if err := c.load(); err != nil {
+				slog.Error("tls certificate reload failed, keeping the current one", "error", err)
+			} else {
+				slog.Info("tls certificate reloaded", "cert", c.certFile)
+			}
+		}
+	}
+
+	return c.cert, nil
+}
+
+// newTLSConfig returns the TLS config of the server when -tls-cert and -tls-key are set
+func newTLSConfig() (*tls.Config, error) {
+	if *tlsCert == "" && *tlsKey == "" {
+		return nil, nil
+	}
+
+	c, err := newCertReloader(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}, nil
+}
+
+// redirectHandler sends every request to the same URL over HTTPS in httpsPort
+func redirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		target := url.URL{
+			Scheme:   "https",
+			Host:     host,
+			Path:     r.URL.Path,
+			RawPath:  r.URL.RawPath,
+			RawQuery: r.URL.RawQuery,
+		}
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+	})
+}
+
+// startRedirect serves the HTTP to HTTPS redirection in -redirect-port to
+// -port, which must be the port of https, the listener of the server
+func startRedirect(https net.Listener) (*http.Server, error) {
+	if _, p, err := net.SplitHostPort(https.Addr().String()); err != nil || p != *port {
+		return nil, fmt.Errorf("-redirect-port redirects to -port %s, the server listens in %s", *port, https.Addr())
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(*host, *redirectPort))
+	if err != nil {
+		return nil, err
+	}
+
+	srv := newServer(redirectHandler(*port))
+	go func() {
+		if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("redirect server failed", "error", err)
+		}
+	}()
+	return srv, nil
+}
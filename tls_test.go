@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCert writes a self-signed certificate for localhost with the given serial
+func writeTestCert(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func serialOf(t *testing.T, cert *tls.Certificate) int64 {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, 1)
+
+	c, err := newCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	cert, err := c.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), serialOf(t, cert))
+
+	// a renewed certificate is picked up once the check interval passed
+	writeTestCert(t, certFile, keyFile, 2)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+
+	cert, err = c.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), serialOf(t, cert))
+
+	c.checked = time.Time{}
+	cert, err = c.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), serialOf(t, cert))
+
+	// a broken certificate keeps the current one
+	require.NoError(t, os.WriteFile(certFile, []byte("broken"), 0o644))
+	c.checked = time.Time{}
+	cert, err = c.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), serialOf(t, cert))
+
+	_, err = newCertReloader(certFile, keyFile)
+	assert.Error(t, err)
+	_, err = newCertReloader(filepath.Join(dir, "missing.pem"), keyFile)
+	assert.Error(t, err)
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, 1)
+
+	oldCert, oldKey := *tlsCert, *tlsKey
+	defer func() { *tlsCert, *tlsKey = oldCert, oldKey }()
+	*tlsCert, *tlsKey = certFile, keyFile
+
+	srv := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	var err error
+	srv.TLSConfig, err = newTLSConfig()
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(srv, l, stop, time.Second)
+	}()
+
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + l.Addr().String())
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "secure", string(body))
+
+	stop <- os.Interrupt
+	assert.NoError(t, <-served)
+}
+
+func TestRedirectHandler(t *testing.T) {
+	for _, tc := range []struct {
+		port, target, want string
+	}{
+		{"8443", "http://opds.example.com:8080/Tolkien/The%20Hobbit.epub?page=2", "https://opds.example.com:8443/Tolkien/The%20Hobbit.epub?page=2"},
+		{"443", "http://opds.example.com/", "https://opds.example.com/"},
+	} {
+		rec := httptest.NewRecorder()
+		redirectHandler(tc.port).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.target, nil))
+		assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+		assert.Equal(t, tc.want, rec.Header().Get("Location"))
+	}
+}
+
+func TestStartRedirect(t *testing.T) {
+	oldHost, oldPort, oldRedirectPort := *host, *port, *redirectPort
+	defer func() {
+		*host, *port, *redirectPort = oldHost, oldPort, oldRedirectPort
+	}()
+	*host = "127.0.0.1"
+	*redirectPort = "0"
+
+	https, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer https.Close()
+	_, *port, err = net.SplitHostPort(https.Addr().String())
+	require.NoError(t, err)
+
+	redirect, err := startRedirect(https)
+	require.NoError(t, err)
+	redirect.Close()
+
+	// the listener of systemd or -socket is not the one of -port
+	sock, err := net.Listen("unix", filepath.Join(t.TempDir(), "dir2opds.sock"))
+	require.NoError(t, err)
+	defer sock.Close()
+	_, err = startRedirect(sock)
+	assert.ErrorContains(t, err, "-redirect-port redirects to -port")
+}